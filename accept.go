@@ -0,0 +1,128 @@
+package xudp
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// handshakeState is the state of the handshake of a connection ID on the
+// server.
+type handshakeState uint8
+
+const (
+	// handshakeAuthPending waits for the Auth frame of the client.
+	handshakeAuthPending handshakeState = iota
+	// handshakeAccepted queued the session for Accept.
+	handshakeAccepted
+	// handshakeRejected refused the identity of the client.
+	handshakeRejected
+)
+
+// serverHandshake is kept by the server for the handshake of a connection
+// ID during the handshake timeout. The handshake frames the client
+// retransmits are answered with the packets already sent, instead of
+// creating another session.
+type serverHandshake struct {
+	state    handshakeState
+	sess     *Sess
+	request  []byte   // the last handshake frame of the client answered
+	response [][]byte // the packets answering it
+}
+
+// startHandshake records the handshake of sess, which answers request.
+func (c *Conn) startHandshake(sess *Sess, request Frame) *serverHandshake {
+	hs := &serverHandshake{
+		sess:    sess,
+		request: request.Bytes(),
+	}
+	id := sess.ConnectionID
+	c.handshakes.Store(id, hs)
+	time.AfterFunc(c.config.handshakeTimeout(), func() {
+		c.handshakes.Delete(id)
+	})
+	return hs
+}
+
+func (c *Conn) getHandshake(id ConnectionID) (*serverHandshake, bool) {
+	hs, ok := c.handshakes.Load(id)
+	if !ok {
+		return nil, ok
+	}
+	return hs.(*serverHandshake), ok
+}
+
+// answer makes request the last handshake frame of the client, whose
+// response is sent from now on.
+func (hs *serverHandshake) answer(request []byte) {
+	hs.request = append([]byte(nil), request...)
+	hs.response = nil
+}
+
+// respond sends a packet of the response to the last handshake frame of
+// the client, and keeps it for the retransmissions of the frame.
+func (c *Conn) respond(hs *serverHandshake, packet []byte) error {
+	hs.response = append(hs.response, packet)
+	_, err := c.conn.WriteTo(packet, hs.sess.remoteAddr())
+	return err
+}
+
+// respondFrame encrypts frame with the keys of the session and sends it as
+// part of the response.
+func (c *Conn) respondFrame(hs *serverHandshake, frame Frame) error {
+	buf, err := hs.sess.encodeFrame(frame)
+	if err != nil {
+		return err
+	}
+	return c.respond(hs, buf)
+}
+
+// retransmitted sends the response again if request is the last handshake
+// frame of the client for id, and reports whether it was. The handshake
+// frames are not authenticated, so the response is only sent to the address
+// of the client: a copy of the frame from any other address is dropped,
+// which keeps the server from amplifying spoofed packets.
+func (c *Conn) retransmitted(id ConnectionID, request []byte, addr net.Addr) bool {
+	hs, ok := c.getHandshake(id)
+	if !ok || !bytes.Equal(hs.request, request) {
+		return false
+	}
+	if addr.String() != hs.sess.remoteAddr().String() {
+		return true
+	}
+	for _, packet := range hs.response {
+		_, _ = c.conn.WriteTo(packet, addr)
+	}
+	return true
+}
+
+// accept issues the alternative connection IDs of the session of hs, as
+// part of the response, and queues the session for Accept. If the queue is
+// full, because the application accepts sessions slower than they come, the
+// session is closed with ServerBusy instead of stalling the Conn.
+func (c *Conn) accept(hs *serverHandshake) error {
+	hs.state = handshakeAccepted
+	hs.sess.pending = false
+	hs.sess.transcript = nil
+	err := c.issueConnectionIDs(hs.sess, func(frame Frame) error {
+		return c.respondFrame(hs, frame)
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case c.accepting <- hs.sess:
+		return nil
+	case <-c.quit:
+		hs.sess.terminate()
+		return ErrConnClosed
+	default:
+	}
+	err = c.respondFrame(hs, &ShutdownFrame{
+		StreamID:  rand.Uint32(),
+		ErrorCode: uint32(ServerBusy),
+	})
+	hs.sess.terminate()
+	return err
+}
@@ -0,0 +1,125 @@
+package xudp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/socketfunc/xudp/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConn_RetransmittedSession(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	// Drop the first SessAck, so that the client retransmits its Session.
+	ln, err := NewListener(&lossyConn{PacketConn: pc, drop: func(n int) bool { return n == 2 }}, nil)
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	client, err := Dial("udp", ln.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+	assert.NotNil(t, client.SessionTicket())
+
+	server, err := ln.Accept()
+	assert.NoError(t, err)
+	assert.NoError(t, client.Send([]byte("ping")))
+	buf, err := server.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("ping"), buf)
+
+	// The session was accepted once.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = ln.AcceptContext(ctx)
+	assert.Equal(t, ErrDeadlineExceeded, err)
+}
+
+func TestConn_DuplicateSession(t *testing.T) {
+	ln, err := ListenWithConfig("127.0.0.1:0", &Config{SessionTicketsDisabled: true})
+	assert.NoError(t, err)
+	defer ln.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer pc.Close()
+
+	sess := newSess(pc, ln.Addr(), &Config{})
+	sess.setConnectionID([]byte("0123456789abcdef"))
+	session := &SessionFrame{
+		StreamID:     1,
+		CipherSuites: (&Config{}).cipherSuites(),
+		Nonce:        newNonce(),
+		Version:      Version1,
+		Versions:     []uint32{Version1},
+	}
+	_, public, err := crypto.GenerateKeys()
+	assert.NoError(t, err)
+	copy(session.Key[:], public.Bytes())
+
+	// SessAck and the alternative connection IDs.
+	count := activeConnectionIDs
+	var responses [][][]byte
+	for i := 0; i < 2; i++ {
+		packet := NewPacket(sess.ConnectionID[:], sess.NextSequence(), defaultChannel, session)
+		assert.NoError(t, sess.send(packet.Bytes()))
+		var response [][]byte
+		for j := 0; j < count; j++ {
+			_ = pc.SetReadDeadline(time.Now().Add(time.Second))
+			buf, err := sess.read()
+			assert.NoError(t, err)
+			response = append(response, buf)
+		}
+		responses = append(responses, response)
+	}
+	assert.Equal(t, responses[0], responses[1])
+	h, err := DecodePacketHeader(responses[0][0])
+	assert.NoError(t, err)
+	assert.Equal(t, SessAck, h.Type)
+
+	// A copy of the Session frame from another address is not answered.
+	other, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer other.Close()
+	packet := NewPacket(sess.ConnectionID[:], sess.NextSequence(), defaultChannel, session)
+	_, err = other.WriteTo(packet.Bytes(), ln.Addr())
+	assert.NoError(t, err)
+	_ = other.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = other.ReadFrom(make([]byte, bufferSize))
+	assert.Error(t, err)
+
+	_, err = ln.Accept()
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = ln.AcceptContext(ctx)
+	assert.Equal(t, ErrDeadlineExceeded, err)
+}
+
+func TestConn_AcceptQueueFull(t *testing.T) {
+	ln, err := ListenWithConfig("127.0.0.1:0", &Config{QueueSize: 1})
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	first, err := Dial("udp", ln.Addr().String())
+	assert.NoError(t, err)
+	defer first.Close()
+	// The session accepted first still waits for Accept, the next one is
+	// closed instead of stalling the Conn.
+	busy, err := Dial("udp", ln.Addr().String())
+	if err == nil {
+		_, err = busy.Receive()
+	}
+	assert.Equal(t, &TransportError{Code: ServerBusy}, err)
+
+	server, err := ln.Accept()
+	assert.NoError(t, err)
+	assertExchange(t, first, server)
+	client, err := Dial("udp", ln.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+	server, err = ln.Accept()
+	assert.NoError(t, err)
+	assertExchange(t, client, server)
+}
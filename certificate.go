@@ -0,0 +1,196 @@
+package xudp
+
+import (
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/socketfunc/xudp/buffer"
+)
+
+const (
+	certificateContext   = "xudp certificate verify"
+	certificateChunkSize = 1024
+	maxCertificateSize   = 64 * 1024
+)
+
+var errCertificateMessage = errors.New("xudp: malformed certificate message")
+
+// encodeCertificateMessage encodes a DER certificate chain, leaf first,
+// followed by the signature of the handshake by the leaf key.
+func encodeCertificateMessage(chain [][]byte, sig []byte) []byte {
+	buf := []byte{uint8(len(chain))}
+	for _, der := range chain {
+		n := make([]byte, 4)
+		binary.BigEndian.PutUint32(n, uint32(len(der)))
+		buf = append(buf, n...)
+		buf = append(buf, der...)
+	}
+	n := make([]byte, 2)
+	binary.BigEndian.PutUint16(n, uint16(len(sig)))
+	buf = append(buf, n...)
+	return append(buf, sig...)
+}
+
+func decodeCertificateMessage(buf []byte) ([][]byte, []byte, error) {
+	if len(buf) < 1 {
+		return nil, nil, errCertificateMessage
+	}
+	count := int(buf[0])
+	buf = buf[1:]
+	chain := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		if len(buf) < 4 {
+			return nil, nil, errCertificateMessage
+		}
+		n := int(binary.BigEndian.Uint32(buf[0:4]))
+		if len(buf) < 4+n {
+			return nil, nil, errCertificateMessage
+		}
+		chain = append(chain, buf[4:4+n])
+		buf = buf[4+n:]
+	}
+	if len(buf) < 2 {
+		return nil, nil, errCertificateMessage
+	}
+	n := int(binary.BigEndian.Uint16(buf[0:2]))
+	if len(buf) != 2+n {
+		return nil, nil, errCertificateMessage
+	}
+	return chain, buf[2:], nil
+}
+
+// signCertificate signs the handshake transcript with the private key of
+// cert.
+func signCertificate(cert *tls.Certificate, transcript []byte) ([]byte, error) {
+	signer, ok := cert.PrivateKey.(gocrypto.Signer)
+	if !ok {
+		return nil, errors.New("xudp: certificate private key does not implement crypto.Signer")
+	}
+	msg := append([]byte(certificateContext), transcript...)
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return signer.Sign(cryptorand.Reader, msg, gocrypto.Hash(0))
+	}
+	digest := sha256.Sum256(msg)
+	return signer.Sign(cryptorand.Reader, digest[:], gocrypto.SHA256)
+}
+
+// certificateFrames splits the certificate message of cert into frames.
+func certificateFrames(cert *tls.Certificate, transcript []byte) ([]*CertificateFrame, error) {
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("xudp: empty certificate chain")
+	}
+	sig, err := signCertificate(cert, transcript)
+	if err != nil {
+		return nil, err
+	}
+	msg := encodeCertificateMessage(cert.Certificate, sig)
+	if len(msg) > maxCertificateSize {
+		return nil, errors.New("xudp: certificate chain too large")
+	}
+	streamID := rand.Uint32()
+	frames := make([]*CertificateFrame, 0, len(msg)/certificateChunkSize+1)
+	err = buffer.Iterator(msg, certificateChunkSize, func(offset int, chunk []byte) error {
+		frames = append(frames, &CertificateFrame{
+			StreamID: streamID,
+			Offset:   offset,
+			Length:   len(msg),
+			Data:     chunk,
+		})
+		return nil
+	})
+	return frames, err
+}
+
+// certificateAssembler reassembles the certificate message of a server.
+type certificateAssembler struct {
+	buf      *buffer.Buffer
+	length   int
+	received int
+	offsets  map[int]bool // fragments received, as the server may resend them
+}
+
+// add stores frame and reports whether the message is complete.
+func (a *certificateAssembler) add(frame *CertificateFrame) (bool, error) {
+	if frame.Length <= 0 || frame.Length > maxCertificateSize ||
+		frame.Offset < 0 || frame.Offset+len(frame.Data) > frame.Length {
+		return false, errCertificateMessage
+	}
+	if a.buf == nil {
+		a.buf = buffer.NewBuffer(frame.Length)
+		a.length = frame.Length
+	}
+	if frame.Length != a.length {
+		return false, errCertificateMessage
+	}
+	if a.offsets[frame.Offset] {
+		return false, nil
+	}
+	if a.offsets == nil {
+		a.offsets = map[int]bool{}
+	}
+	a.offsets[frame.Offset] = true
+	a.buf.WriteBytes(frame.Data, frame.Offset)
+	a.received += len(frame.Data)
+	return a.received >= a.length, nil
+}
+
+// verifyCertificate verifies the certificate chain sent by the server
+// against the roots and server name of config, then checks that the leaf key
+// signed the handshake transcript.
+func verifyCertificate(config *Config, msg, transcript []byte) ([]*x509.Certificate, error) {
+	chain, sig, err := decodeCertificateMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("%w: empty certificate chain", ErrServerAuthentication)
+	}
+	certs := make([]*x509.Certificate, 0, len(chain))
+	for _, der := range chain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrServerAuthentication, err)
+		}
+		certs = append(certs, cert)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         config.RootCAs,
+		DNSName:       config.ServerName,
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   time.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrServerAuthentication, err)
+	}
+	var algo x509.SignatureAlgorithm
+	switch certs[0].PublicKey.(type) {
+	case ed25519.PublicKey:
+		algo = x509.PureEd25519
+	case *ecdsa.PublicKey:
+		algo = x509.ECDSAWithSHA256
+	case *rsa.PublicKey:
+		algo = x509.SHA256WithRSA
+	default:
+		return nil, fmt.Errorf("%w: unsupported certificate key", ErrServerAuthentication)
+	}
+	msg = append([]byte(certificateContext), transcript...)
+	if err := certs[0].CheckSignature(algo, msg, sig); err != nil {
+		return nil, fmt.Errorf("%w: invalid handshake signature", ErrServerAuthentication)
+	}
+	return certs, nil
+}
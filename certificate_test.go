@@ -0,0 +1,112 @@
+package xudp
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCertificate(t *testing.T) (*tls.Certificate, *x509.CertPool) {
+	caPublic, caPrivate, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "xudp test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, caPublic, caPrivate)
+	assert.NoError(t, err)
+	ca, err = x509.ParseCertificate(caDER)
+	assert.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	// Enough names for the chain to be split over several packets.
+	names := []string{"xudp.test"}
+	for i := 0; i < 64; i++ {
+		names = append(names, fmt.Sprintf("host-%d.xudp.test", i))
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "xudp.test"},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caPrivate)
+	assert.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	return &tls.Certificate{
+		Certificate: [][]byte{leafDER, caDER},
+		PrivateKey:  key,
+	}, roots
+}
+
+func TestVerifyCertificate(t *testing.T) {
+	cert, roots := testCertificate(t)
+	transcript := []byte("transcript")
+
+	frames, err := certificateFrames(cert, transcript)
+	assert.NoError(t, err)
+	assert.True(t, len(frames) > 1)
+
+	assembler := &certificateAssembler{}
+	for i := len(frames) - 1; i >= 0; i-- {
+		done, err := assembler.add(decodeCertificateFrame(frames[i].Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, i == 0, done)
+	}
+	msg := assembler.buf.Bytes()
+
+	certs, err := verifyCertificate(&Config{RootCAs: roots, ServerName: "xudp.test"}, msg, transcript)
+	assert.NoError(t, err)
+	assert.Len(t, certs, 2)
+
+	_, err = verifyCertificate(&Config{RootCAs: roots, ServerName: "other.test"}, msg, transcript)
+	assert.True(t, errors.Is(err, ErrServerAuthentication))
+
+	_, err = verifyCertificate(&Config{RootCAs: roots, ServerName: "xudp.test"}, msg, []byte("other"))
+	assert.True(t, errors.Is(err, ErrServerAuthentication))
+
+	_, err = verifyCertificate(&Config{RootCAs: x509.NewCertPool()}, msg, transcript)
+	assert.True(t, errors.Is(err, ErrServerAuthentication))
+}
+
+func TestHandshake_Certificate(t *testing.T) {
+	cert, roots := testCertificate(t)
+	ln, err := ListenWithConfig("127.0.0.1:0", &Config{Certificate: cert})
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	client, err := DialWithConfig("udp", ln.Addr().String(), &Config{RootCAs: roots, ServerName: "xudp.test"})
+	assert.NoError(t, err)
+	defer client.Close()
+	server, err := ln.Accept()
+	assert.NoError(t, err)
+	assert.Len(t, client.PeerCertificates(), 2)
+	assert.Equal(t, "xudp.test", client.PeerCertificates()[0].Subject.CommonName)
+	assertExchange(t, client, server)
+
+	_, err = DialWithConfig("udp", ln.Addr().String(), &Config{RootCAs: roots, ServerName: "other.test"})
+	assert.True(t, errors.Is(err, ErrServerAuthentication))
+	_, err = DialWithConfig("udp", ln.Addr().String(), &Config{RootCAs: x509.NewCertPool()})
+	assert.True(t, errors.Is(err, ErrServerAuthentication))
+}
@@ -0,0 +1,210 @@
+package xudp
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	closeRetries  = 3
+	maxReasonSize = 1024
+)
+
+var errShutdownTimeout = errors.New("xudp: shutdown not acknowledged by the peer")
+
+// TransportErrorCode is the reason of a session closed by xudp itself rather
+// than by the application.
+type TransportErrorCode uint32
+
+const (
+	NoError TransportErrorCode = iota
+	InternalError
+	ProtocolViolation
+	ServerBusy
+)
+
+func (c TransportErrorCode) String() string {
+	switch c {
+	case NoError:
+		return "no error"
+	case InternalError:
+		return "internal error"
+	case ProtocolViolation:
+		return "protocol violation"
+	case ServerBusy:
+		return "server busy"
+	}
+	return fmt.Sprintf("transport error %d", uint32(c))
+}
+
+// TransportError is returned by Send and Receive once the peer closed the
+// session because of a transport error.
+type TransportError struct {
+	Code   TransportErrorCode
+	Reason string
+}
+
+func (e *TransportError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("xudp: session closed by the peer: %v", e.Code)
+	}
+	return fmt.Sprintf("xudp: session closed by the peer: %v: %s", e.Code, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrSessionClosed) true.
+func (e *TransportError) Is(target error) bool {
+	return target == ErrSessionClosed
+}
+
+// ApplicationError is returned by Send and Receive once the peer closed the
+// session with Sess.CloseWithError. The meaning of Code is up to the
+// application.
+type ApplicationError struct {
+	Code   uint32
+	Reason string
+}
+
+func (e *ApplicationError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("xudp: session closed by the peer: application error %d", e.Code)
+	}
+	return fmt.Sprintf("xudp: session closed by the peer: application error %d: %s", e.Code, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrSessionClosed) true.
+func (e *ApplicationError) Is(target error) bool {
+	return target == ErrSessionClosed
+}
+
+// closeError returns the error of a Shutdown frame received from the peer.
+func closeError(frame *ShutdownFrame) error {
+	if frame.Application {
+		return &ApplicationError{Code: frame.ErrorCode, Reason: frame.Reason}
+	}
+	if TransportErrorCode(frame.ErrorCode) == NoError {
+		return ErrSessionClosed
+	}
+	return &TransportError{Code: TransportErrorCode(frame.ErrorCode), Reason: frame.Reason}
+}
+
+// Close waits for the messages being sent, then tells the peer that the
+// session ends and waits for its acknowledgement, sending the Shutdown frame
+// up to closeRetries times. The session is released even if the peer never
+// answers, and Send and Receive return ErrSessionClosed afterwards.
+func (s *Sess) Close() error {
+	return s.close(&ShutdownFrame{
+		StreamID:  rand.Uint32(),
+		ErrorCode: uint32(NoError),
+	})
+}
+
+// CloseWithError is like Close, but the peer gets an ApplicationError with
+// code and reason from Send and Receive instead of ErrSessionClosed. The
+// reason is truncated to maxReasonSize bytes.
+func (s *Sess) CloseWithError(code uint32, reason string) error {
+	if len(reason) > maxReasonSize {
+		reason = reason[:maxReasonSize]
+	}
+	return s.close(&ShutdownFrame{
+		StreamID:    rand.Uint32(),
+		Application: true,
+		ErrorCode:   code,
+		Reason:      reason,
+	})
+}
+
+func (s *Sess) close(frame *ShutdownFrame) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return ErrSessionClosed
+	}
+	s.closing = true
+	s.closeErr = ErrSessionClosed
+	s.mu.Unlock()
+	s.sending.Wait()
+	defer s.terminate()
+
+	if s.hp == nil {
+		// The handshake never completed, there is nobody to tell.
+		return nil
+	}
+	for i := 0; i < closeRetries; i++ {
+		if err := s.writeFrame(frame); err != nil {
+			return err
+		}
+		select {
+		case <-s.shutAck:
+			return nil
+		case <-s.quit:
+			// The peer closed the session at the same time.
+			return nil
+		case <-time.After(s.closeTimeout):
+		}
+	}
+	return errShutdownTimeout
+}
+
+// shutdown acknowledges the Shutdown frame of the peer and releases the
+// session.
+func (s *Sess) shutdown(frame *ShutdownFrame) error {
+	s.mu.Lock()
+	s.closing = true
+	if s.closeErr == nil {
+		s.closeErr = closeError(frame)
+	}
+	s.mu.Unlock()
+	err := s.writeFrame(&ShutAckFrame{StreamID: frame.StreamID})
+	s.terminate()
+	return err
+}
+
+// expire releases the session without telling the peer, which is deemed
+// gone. Send and Receive return err afterwards.
+func (s *Sess) expire(err error) {
+	s.mu.Lock()
+	s.closing = true
+	if s.closeErr == nil {
+		s.closeErr = err
+	}
+	s.mu.Unlock()
+	s.terminate()
+}
+
+// closeError returns the error Send and Receive return once the session is
+// closed.
+func (s *Sess) closeError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closeErr == nil {
+		return ErrSessionClosed
+	}
+	return s.closeErr
+}
+
+// terminate releases the session: it is forgotten by the Conn which accepted
+// it, or its socket is closed if it was dialed, and its readers are
+// unblocked.
+func (s *Sess) terminate() {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		if s.idleTimer != nil {
+			s.idleTimer.Stop()
+		}
+		if s.ticker != nil {
+			s.ticker.Stop()
+		}
+		s.mu.Unlock()
+		close(s.quit)
+		if s.server != nil {
+			s.server.sessions.Delete(s.ConnectionID)
+			for _, id := range s.activeConnectionIDs() {
+				s.server.sessions.Delete(id)
+			}
+		} else if s.conn != nil {
+			s.conn.Close()
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package xudp
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSess_Close(t *testing.T) {
+	sess := NewSess(nil, nil, nil)
+	sess.incoming <- []byte{1}
+	assert.NoError(t, sess.Close())
+	assert.Equal(t, ErrSessionClosed, sess.Close())
+
+	buf, err := sess.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1}, buf)
+	_, err = sess.Receive()
+	assert.Equal(t, ErrSessionClosed, err)
+	assert.Equal(t, ErrSessionClosed, sess.Send([]byte{2}))
+}
+
+func TestShutdownFrame(t *testing.T) {
+	frame := &ShutdownFrame{
+		StreamID:    1,
+		Application: true,
+		ErrorCode:   403,
+		Reason:      "banned",
+	}
+	decoded := decodeShutdownFrame(frame.Bytes())
+	assert.Equal(t, frame, decoded)
+
+	err := closeError(decoded)
+	assert.Equal(t, &ApplicationError{Code: 403, Reason: "banned"}, err)
+	assert.True(t, errors.Is(err, ErrSessionClosed))
+
+	assert.Equal(t, ErrSessionClosed, closeError(&ShutdownFrame{}))
+	err = closeError(&ShutdownFrame{ErrorCode: uint32(ProtocolViolation)})
+	assert.Equal(t, &TransportError{Code: ProtocolViolation}, err)
+	assert.Equal(t, "xudp: session closed by the peer: protocol violation", err.Error())
+}
+
+func TestSess_Close_Shutdown(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	for _, serverCloses := range []bool{false, true} {
+		client, err := Dial("udp", ln.Addr().String())
+		assert.NoError(t, err)
+		server, err := ln.Accept()
+		assert.NoError(t, err)
+		closer, peer := client, server
+		if serverCloses {
+			closer, peer = server, client
+		}
+
+		// The peer acknowledges the Shutdown frame, so Close does not time
+		// out, and is closed as well.
+		assert.NoError(t, closer.Close())
+		_, err = peer.Receive()
+		assert.Equal(t, ErrSessionClosed, err)
+		assert.Equal(t, ErrSessionClosed, peer.Send([]byte("ping")))
+		_, ok := ln.getSess(server.ConnectionID)
+		assert.False(t, ok)
+	}
+}
+
+func TestSess_CloseWithError(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	client, err := Dial("udp", ln.Addr().String())
+	assert.NoError(t, err)
+	server, err := ln.Accept()
+	assert.NoError(t, err)
+
+	assert.NoError(t, server.CloseWithError(403, "banned"))
+	_, err = client.Receive()
+	assert.Equal(t, &ApplicationError{Code: 403, Reason: "banned"}, err)
+	assert.True(t, errors.Is(err, ErrSessionClosed))
+	assert.Equal(t, err, client.Send([]byte("ping")))
+}
@@ -0,0 +1,265 @@
+package xudp
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+
+	"github.com/socketfunc/xudp/crypto"
+)
+
+// Config is used to configure a Conn returned by ListenWithConfig or
+// ListenConfig, or a session returned by DialWithConfig or a Dialer. A nil
+// Config is the same as the zero value.
+type Config struct {
+	// HeaderProtection masks the type and sequence number of outgoing
+	// encrypted packets, so that only the connection id is visible on the
+	// network. Incoming protected packets are always accepted.
+	HeaderProtection bool
+
+	// KeyUpdatePackets and KeyUpdateBytes make a session update its sending
+	// keys after that many packets or frame bytes were sent with them. Zero
+	// disables the limit. An update waits until the peer followed the
+	// previous one, as reported by Stats.KeyUpdatePending. Sess.UpdateKey
+	// updates the keys on demand.
+	KeyUpdatePackets uint64
+	KeyUpdateBytes   uint64
+
+	// IdleTimeout closes a session when no packet was received from the peer
+	// for that long. Both sides announce theirs in the handshake and the
+	// shortest one is used. If zero, 30 seconds is used.
+	IdleTimeout time.Duration
+
+	// KeepAlive makes sessions send a Ping frame whenever they have sent
+	// nothing for a fraction of the idle timeout, so that idle sessions stay
+	// open. A session is closed with a KeepAliveTimeoutError once
+	// KeepAliveMaxMissed Pings in a row, 3 if zero, went unanswered while
+	// nothing else was received from the peer.
+	KeepAlive          bool
+	KeepAliveMaxMissed int
+
+	// HandshakeTimeout bounds the time a client waits for the handshake to
+	// complete. If zero, 10 seconds is used.
+	HandshakeTimeout time.Duration
+
+	// CloseTimeout is how long Sess.Close waits for the peer to acknowledge
+	// its Shutdown frame before sending it again. If zero, 500 milliseconds
+	// is used.
+	CloseTimeout time.Duration
+
+	// ReadBufferSize is the size of the buffers packets are read into,
+	// larger packets are truncated. If zero, 4096 bytes is used.
+	ReadBufferSize int
+
+	// QueueSize is the number of messages a session holds until they are
+	// received, and of sessions a Conn holds until they are accepted.
+	// Messages arriving while the queue is full are dropped. If zero, 128
+	// is used.
+	QueueSize int
+
+	// ChunkSize is the largest part of a message carried by one Data frame.
+	// If zero or larger than 1024 bytes, 1024 bytes is used.
+	ChunkSize int
+
+	// CompressionLevel is the zstd level messages are compressed with. If
+	// zero, 9 is used.
+	CompressionLevel int
+
+	// Channel is the channel number written in the header of the packets a
+	// session sends. If zero, 1 is used.
+	Channel uint8
+
+	// Versions is the list of wire format versions to use, most preferred
+	// first. A client starts the handshake with the first one and falls
+	// back to another if the server does not support it. Versions not in
+	// SupportedVersions are ignored. If nil, SupportedVersions is used.
+	Versions []uint32
+
+	// CipherSuites is the list of supported cipher suites. A client offers
+	// all of them in the handshake and a server selects the first one of its
+	// list that the client offered. If nil, crypto.DefaultCipherSuites is
+	// used.
+	CipherSuites []crypto.CipherSuite
+
+	// Identity is the long-term key of a server. When set, the server signs
+	// the handshake with it so that clients can authenticate it.
+	Identity ed25519.PrivateKey
+
+	// ServerKey pins the identity a client expects the server to sign the
+	// handshake with. The handshake fails if the signature is missing, invalid
+	// or made with another key.
+	ServerKey ed25519.PublicKey
+
+	// VerifyServerKey is called by a client with the server identity once the
+	// handshake signature has been verified. Returning an error aborts the
+	// handshake. It may be used instead of, or in addition to, ServerKey.
+	VerifyServerKey func(key ed25519.PublicKey) error
+
+	// Certificate is the certificate chain and private key a server
+	// authenticates with. The chain is sent encrypted after SessAck, split
+	// over as many packets as needed, together with a signature of the
+	// handshake by the leaf key.
+	Certificate *tls.Certificate
+
+	// RootCAs is the set of root certificates a client verifies the server
+	// certificate with. If nil, the host's root CA set is used.
+	RootCAs *x509.CertPool
+
+	// ServerName is checked against the server certificate by a client. When
+	// either RootCAs or ServerName is set, servers which do not present a
+	// certificate are rejected.
+	ServerName string
+
+	// Authenticator makes a server require clients to authenticate. It is
+	// called with the identity presented by the client before the session
+	// is returned by Accept, and the session is rejected if it returns an
+	// error. Resumed sessions are checked again with the identity of their
+	// ticket, which is refused if it returns an error.
+	Authenticator func(sess *Sess, id *ClientIdentity) error
+
+	// ClientKey is the long-term key a client proves possession of when the
+	// server requires authentication.
+	ClientKey ed25519.PrivateKey
+
+	// Credential is an opaque credential, such as a token, a client presents
+	// when the server requires authentication. It is sent encrypted and must
+	// fit in a single packet.
+	Credential []byte
+
+	// PSK is a symmetric key provisioned on both a client and the server.
+	// When set, the client names it by PSKIdentity, at most 255 bytes, in
+	// the handshake and the session secret is derived from it and fresh
+	// nonces. Both sides prove they hold the key before the session is
+	// established.
+	PSK         []byte
+	PSKIdentity []byte
+
+	// PSKForwardSecrecy makes a client combine the pre-shared key with an
+	// ECDH exchange, so that a later compromise of the key does not reveal
+	// past sessions. Without it no key pair is generated.
+	PSKForwardSecrecy bool
+
+	// GetPSK is called by a server with the identity a client named and
+	// returns the matching pre-shared key. Sessions naming an identity are
+	// refused if it is nil or returns an error, as are the tickets of
+	// sessions established with it.
+	GetPSK func(identity []byte) ([]byte, error)
+
+	// SessionTicketsDisabled stops a server from issuing session tickets.
+	SessionTicketsDisabled bool
+
+	// SessionTicketKey encrypts the session tickets of a server. Servers
+	// sharing a key accept each other's tickets. If zero, a random key is
+	// generated by each Conn, so tickets do not survive a restart.
+	SessionTicketKey [32]byte
+
+	// SessionTicket makes a client resume the session the ticket was issued
+	// for, in one round trip, falling back to a full handshake if the server
+	// rejects it. A server rejects the tickets of clients its Authenticator
+	// or GetPSK no longer accepts.
+	SessionTicket *SessionTicket
+
+	// ConnectionIDGenerator generates the connection IDs a server issues to
+	// its clients, which switch to the first one once the handshake
+	// completed. If nil, the connection IDs are random.
+	ConnectionIDGenerator ConnectionIDGenerator
+}
+
+func (c *Config) connectionIDGenerator() ConnectionIDGenerator {
+	if c.ConnectionIDGenerator == nil {
+		return randomConnectionIDGenerator{}
+	}
+	return c.ConnectionIDGenerator
+}
+
+func (c *Config) handshakeTimeout() time.Duration {
+	if c.HandshakeTimeout <= 0 {
+		return defaultHandshakeTimeout
+	}
+	return c.HandshakeTimeout
+}
+
+func (c *Config) closeTimeout() time.Duration {
+	if c.CloseTimeout <= 0 {
+		return defaultCloseTimeout
+	}
+	return c.CloseTimeout
+}
+
+func (c *Config) readBufferSize() int {
+	if c.ReadBufferSize <= 0 {
+		return bufferSize
+	}
+	return c.ReadBufferSize
+}
+
+func (c *Config) queueSize() int {
+	if c.QueueSize <= 0 {
+		return queueSize
+	}
+	return c.QueueSize
+}
+
+func (c *Config) chunkSize() int {
+	if c.ChunkSize <= 0 || c.ChunkSize > maxChunkSize {
+		return maxChunkSize
+	}
+	return c.ChunkSize
+}
+
+func (c *Config) compressionLevel() int {
+	if c.CompressionLevel == 0 {
+		return defaultCompressionLevel
+	}
+	return c.CompressionLevel
+}
+
+func (c *Config) channel() uint8 {
+	if c.Channel == 0 {
+		return defaultChannel
+	}
+	return c.Channel
+}
+
+func (c *Config) idleTimeout() time.Duration {
+	if c.IdleTimeout <= 0 {
+		return defaultIdleTimeout
+	}
+	return c.IdleTimeout
+}
+
+func (c *Config) keepAliveMaxMissed() int {
+	if c.KeepAliveMaxMissed <= 0 {
+		return defaultKeepAliveMaxMissed
+	}
+	return c.KeepAliveMaxMissed
+}
+
+func (c *Config) versions() []uint32 {
+	if c.Versions == nil {
+		return SupportedVersions
+	}
+	versions := make([]uint32, 0, len(c.Versions))
+	for _, version := range c.Versions {
+		if hasVersion(SupportedVersions, version) {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+func (c *Config) cipherSuites() []crypto.CipherSuite {
+	if c.CipherSuites == nil {
+		return crypto.DefaultCipherSuites
+	}
+	return c.CipherSuites
+}
+
+func (c *Config) clone() *Config {
+	if c == nil {
+		return &Config{}
+	}
+	cc := *c
+	return &cc
+}
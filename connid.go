@@ -0,0 +1,204 @@
+package xudp
+
+import (
+	"errors"
+	"math/rand"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// activeConnectionIDs is the number of connection IDs a server keeps active
+// for a session, including the one of the handshake.
+const activeConnectionIDs = 4
+
+// maxConnectionIDAttempts bounds the attempts to generate a connection ID
+// which is not in use.
+const maxConnectionIDAttempts = 8
+
+// ConnectionIDGenerator generates the connection IDs a server is addressed
+// by. A generator can encode routing information in them, such as the
+// server a load balancer forwards the packets to, as
+// ShardConnectionIDGenerator does. It must be safe for concurrent use.
+type ConnectionIDGenerator interface {
+	GenerateConnectionID() (ConnectionID, error)
+}
+
+type randomConnectionIDGenerator struct{}
+
+func (randomConnectionIDGenerator) GenerateConnectionID() (ConnectionID, error) {
+	var id ConnectionID
+	copy(id[:], uuid.NewV4().Bytes())
+	return id, nil
+}
+
+// setConnectionID sets the connection ID of the handshake, which is also
+// the first one the packets are sent with.
+func (s *Sess) setConnectionID(id []byte) {
+	copy(s.ConnectionID[:], id)
+	s.cidMu.Lock()
+	defer s.cidMu.Unlock()
+	s.sendID = s.ConnectionID
+	s.sendIDSeq = 0
+	s.connectionIDs = map[uint32]ConnectionID{0: s.ConnectionID}
+}
+
+// sendConnectionID returns the connection ID of the packets sent.
+func (s *Sess) sendConnectionID() ConnectionID {
+	s.cidMu.Lock()
+	defer s.cidMu.Unlock()
+	return s.sendID
+}
+
+// activeConnectionIDs returns the connection IDs the session can be
+// addressed by.
+func (s *Sess) activeConnectionIDs() []ConnectionID {
+	s.cidMu.Lock()
+	defer s.cidMu.Unlock()
+	ids := make([]ConnectionID, 0, len(s.connectionIDs))
+	for _, id := range s.connectionIDs {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// RotateConnectionID switches a dialed session to the next connection ID
+// issued by the server and retires the current one, so that observers
+// cannot link the packets sent before and after. Call it when moving to
+// another network. It returns ErrNoConnectionID if the server has not
+// issued another connection ID yet.
+func (s *Sess) RotateConnectionID() error {
+	if !s.dialer {
+		return errors.New("xudp: only dialed sessions rotate connection ids")
+	}
+	s.cidMu.Lock()
+	next, ok := uint32(0), false
+	for seq := range s.connectionIDs {
+		if seq > s.sendIDSeq && (!ok || seq < next) {
+			next, ok = seq, true
+		}
+	}
+	if !ok {
+		s.cidMu.Unlock()
+		return ErrNoConnectionID
+	}
+	retired := s.sendIDSeq
+	retiredID := s.connectionIDs[retired]
+	delete(s.connectionIDs, retired)
+	s.sendIDSeq = next
+	s.sendID = s.connectionIDs[next]
+	s.cidMu.Unlock()
+
+	if s.inbox != nil && retiredID != s.ConnectionID {
+		s.server.sessions.Delete(retiredID)
+	}
+	return s.writeFrame(&RetireConnectionIDFrame{StreamID: rand.Uint32(), Sequence: retired})
+}
+
+// addConnectionID stores a connection ID issued by the server. The session
+// switches to the first one, chosen by the server, as soon as it arrives.
+// If the Conn of the session already routes the connection ID to another
+// session, the connection ID is ignored, so that a server cannot take the
+// packets of other sessions.
+func (s *Sess) addConnectionID(frame *NewConnectionIDFrame) error {
+	s.cidMu.Lock()
+	if frame.Sequence <= s.sendIDSeq || len(s.connectionIDs) >= activeConnectionIDs {
+		s.cidMu.Unlock()
+		return nil
+	}
+	if s.inbox != nil && !s.server.claimSess(frame.ConnectionID, s) {
+		s.cidMu.Unlock()
+		return nil
+	}
+	s.connectionIDs[frame.Sequence] = frame.ConnectionID
+	first := s.sendIDSeq == 0
+	s.cidMu.Unlock()
+
+	if first {
+		return s.RotateConnectionID()
+	}
+	return nil
+}
+
+// useConnectionID makes the server answer with the connection ID the client
+// switched to, once a packet carrying it was authenticated.
+func (s *Sess) useConnectionID(id ConnectionID) {
+	s.cidMu.Lock()
+	defer s.cidMu.Unlock()
+	if id == s.sendID {
+		return
+	}
+	for seq, active := range s.connectionIDs {
+		if active == id && seq > s.sendIDSeq {
+			s.sendID = id
+			s.sendIDSeq = seq
+			return
+		}
+	}
+}
+
+// retireConnectionID forgets a connection ID the client no longer uses, and
+// issues another one. The connection ID of the handshake stays reserved for
+// the session until it terminates, so that a replayed Session or Resume
+// packet cannot create another session once the handshake is forgotten,
+// and packets the client sent before switching are still received.
+func (s *Sess) retireConnectionID(frame *RetireConnectionIDFrame) error {
+	s.cidMu.Lock()
+	id, ok := s.connectionIDs[frame.Sequence]
+	if !ok || frame.Sequence == s.sendIDSeq {
+		s.cidMu.Unlock()
+		return nil
+	}
+	delete(s.connectionIDs, frame.Sequence)
+	s.cidMu.Unlock()
+
+	if id != s.ConnectionID {
+		s.server.sessions.Delete(id)
+	}
+	return s.server.issueConnectionIDs(s, s.writeFrame)
+}
+
+// issueConnectionIDs gives the client of sess new connection IDs, sent with
+// send, until it has activeConnectionIDs of them.
+func (c *Conn) issueConnectionIDs(sess *Sess, send func(Frame) error) error {
+	for {
+		sess.cidMu.Lock()
+		if len(sess.connectionIDs) >= activeConnectionIDs {
+			sess.cidMu.Unlock()
+			return nil
+		}
+		id, err := c.newConnectionID()
+		if err != nil {
+			sess.cidMu.Unlock()
+			return err
+		}
+		sess.nextIDSeq++
+		seq := sess.nextIDSeq
+		sess.connectionIDs[seq] = id
+		sess.cidMu.Unlock()
+
+		c.setSess(id, sess)
+		frame := &NewConnectionIDFrame{
+			StreamID:     rand.Uint32(),
+			Sequence:     seq,
+			ConnectionID: id,
+		}
+		if err := send(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// newConnectionID generates a connection ID no session of the Conn uses.
+func (c *Conn) newConnectionID() (ConnectionID, error) {
+	generator := c.config.connectionIDGenerator()
+	for i := 0; i < maxConnectionIDAttempts; i++ {
+		id, err := generator.GenerateConnectionID()
+		if err != nil {
+			return ConnectionID{}, err
+		}
+		if _, ok := c.getSess(id); !ok {
+			return id, nil
+		}
+	}
+	return ConnectionID{}, errors.New("xudp: connection id generator only returned ids in use")
+}
@@ -0,0 +1,195 @@
+package xudp
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitConnectionIDs waits until sess has n active connection IDs.
+func waitConnectionIDs(t *testing.T, sess *Sess, n int) {
+	deadline := time.Now().Add(time.Second)
+	for len(sess.activeConnectionIDs()) < n && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Len(t, sess.activeConnectionIDs(), n)
+}
+
+func TestSess_RotateConnectionID(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	peer, err := Listen("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer peer.Close()
+
+	dialed, err := Dial("udp", ln.Addr().String())
+	assert.NoError(t, err)
+	defer dialed.Close()
+	// A session dialed from a Conn receives its packets through the Conn.
+	shared, err := peer.Dial(ln.Addr())
+	assert.NoError(t, err)
+	defer shared.Close()
+
+	for _, client := range []*Sess{dialed, shared} {
+		server, err := ln.Accept()
+		assert.NoError(t, err)
+		for _, id := range server.activeConnectionIDs() {
+			sess, ok := ln.getSess(id)
+			assert.True(t, ok)
+			assert.Equal(t, server, sess)
+		}
+		waitConnectionIDs(t, client, activeConnectionIDs)
+
+		for i := 0; i < activeConnectionIDs+1; i++ {
+			old := client.sendConnectionID()
+			assert.NoError(t, client.RotateConnectionID())
+			assert.NotEqual(t, old, client.sendConnectionID())
+
+			assert.NoError(t, client.Send([]byte("ping")))
+			buf, err := server.Receive()
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("ping"), buf)
+			assert.Equal(t, client.sendConnectionID(), server.sendConnectionID())
+			_, ok := ln.getSess(old)
+			assert.False(t, ok)
+
+			assert.NoError(t, server.Send([]byte("pong")))
+			buf, err = client.Receive()
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("pong"), buf)
+			// The server replaces the retired connection ID.
+			waitConnectionIDs(t, client, activeConnectionIDs)
+		}
+	}
+}
+
+// recordingConn keeps a copy of the packets written.
+type recordingConn struct {
+	net.PacketConn
+
+	mu      sync.Mutex
+	written [][]byte
+}
+
+func (c *recordingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	c.written = append(c.written, append([]byte(nil), b...))
+	c.mu.Unlock()
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+// packet returns the first packet of type typ written.
+func (c *recordingConn) packet(typ Type) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, b := range c.written {
+		if h, err := DecodePacketHeader(b); err == nil && !h.Protected() && h.Type == typ {
+			return b
+		}
+	}
+	return nil
+}
+
+func TestConn_ReplayedSessionAfterRotation(t *testing.T) {
+	ln, err := ListenWithConfig("127.0.0.1:0", &Config{HandshakeTimeout: 200 * time.Millisecond})
+	assert.NoError(t, err)
+	defer ln.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	conn := &recordingConn{PacketConn: pc}
+	client, err := NewClient(conn, ln.Addr(), nil)
+	assert.NoError(t, err)
+	defer client.Close()
+	server, err := ln.Accept()
+	assert.NoError(t, err)
+
+	// The client moved away from the connection ID of the handshake, which
+	// the server forgot about.
+	waitConnectionIDs(t, client, activeConnectionIDs)
+	assert.NotEqual(t, client.ConnectionID, client.sendConnectionID())
+	assert.NoError(t, client.Send([]byte("ping")))
+	buf, err := server.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("ping"), buf)
+	time.Sleep(500 * time.Millisecond)
+	_, ok := ln.getHandshake(client.ConnectionID)
+	assert.False(t, ok)
+
+	attacker, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer attacker.Close()
+	session := conn.packet(Session)
+	assert.NotNil(t, session)
+	_, err = attacker.WriteTo(session, ln.Addr())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = ln.AcceptContext(ctx)
+	assert.Equal(t, ErrDeadlineExceeded, err)
+}
+
+func TestSess_RotateConnectionID_Exhausted(t *testing.T) {
+	sess := NewSess(nil, nil, nil)
+	sess.dialer = true
+	sess.setConnectionID([]byte("0123456789abcdef"))
+	assert.Equal(t, ErrNoConnectionID, sess.RotateConnectionID())
+}
+
+func TestSess_AddConnectionID_InUse(t *testing.T) {
+	conn, err := Listen("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+	victim := conn.newSess(conn.Addr())
+	victim.setConnectionID([]byte("0123456789abcdef"))
+	conn.setSess(victim.ConnectionID, victim)
+	sess := conn.newSess(conn.Addr())
+	sess.dialer = true
+	sess.inbox = make(chan []byte, 1)
+	sess.setConnectionID([]byte("fedcba9876543210"))
+	conn.setSess(sess.ConnectionID, sess)
+
+	// The server of sess issues the connection ID of another session of
+	// the Conn, which keeps receiving its packets.
+	assert.NoError(t, sess.addConnectionID(&NewConnectionIDFrame{
+		Sequence:     1,
+		ConnectionID: victim.ConnectionID,
+	}))
+	assert.Equal(t, []ConnectionID{sess.ConnectionID}, sess.activeConnectionIDs())
+	routed, ok := conn.getSess(victim.ConnectionID)
+	assert.True(t, ok)
+	assert.Equal(t, victim, routed)
+
+	assert.True(t, conn.claimSess(sess.ConnectionID, sess))
+	assert.False(t, conn.claimSess(sess.ConnectionID, victim))
+}
+
+func TestConn_ConnectionIDGenerator(t *testing.T) {
+	g, err := NewShardConnectionIDGenerator(42, make([]byte, 16))
+	assert.NoError(t, err)
+	ln, err := ListenWithConfig("127.0.0.1:0", &Config{ConnectionIDGenerator: g})
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	client, err := Dial("udp", ln.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+	server, err := ln.Accept()
+	assert.NoError(t, err)
+
+	// The client switches to the connection ID chosen by the server.
+	waitConnectionIDs(t, client, activeConnectionIDs)
+	for _, id := range client.activeConnectionIDs() {
+		assert.Equal(t, uint32(42), g.Shard(id))
+	}
+	assert.NoError(t, client.Send([]byte("ping")))
+	buf, err := server.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("ping"), buf)
+	assert.Equal(t, uint32(42), g.Shard(server.sendConnectionID()))
+}
@@ -0,0 +1,22 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncrypt(t *testing.T) {
+	key := make([]byte, 32)
+	header := []byte{5, 1, 2, 3, 4}
+
+	buf, err := Encrypt(key, []byte("hello"), header)
+	assert.NoError(t, err)
+
+	plain, err := Decrypt(key, buf, header)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), plain)
+
+	_, err = Decrypt(key, buf, []byte{7, 1, 2, 3, 4})
+	assert.Error(t, err)
+}
@@ -32,23 +32,24 @@ type PublicKey struct {
 func (p *PublicKey) Bytes() []byte {
 	switch t := p.key.(type) {
 	case ecdh.Point:
-		x := t.X.Bytes()
-		y := t.Y.Bytes()
-		buf := make([]byte, 0, len(x)+len(y))
-		buf = append(buf, x...)
-		buf = append(buf, y...)
-		return buf
+		return pointBytes(&t)
 	case *ecdh.Point:
-		x := t.X.Bytes()
-		y := t.Y.Bytes()
-		buf := make([]byte, 0, len(x)+len(y))
-		buf = append(buf, x...)
-		buf = append(buf, y...)
-		return buf
+		return pointBytes(t)
 	}
 	return nil
 }
 
+// pointBytes encodes both coordinates on 32 bytes, keeping leading zeros so
+// that GeneratePublicKey can split them again.
+func pointBytes(p *ecdh.Point) []byte {
+	buf := make([]byte, 64)
+	x := p.X.Bytes()
+	y := p.Y.Bytes()
+	copy(buf[32-len(x):32], x)
+	copy(buf[64-len(y):], y)
+	return buf
+}
+
 func GenerateKeys() (*PrivateKey, *PublicKey, error) {
 	private, public, err := p256.GenerateKey(rand.Reader)
 	if err != nil {
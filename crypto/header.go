@@ -0,0 +1,34 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+)
+
+// SampleSize is the number of cipher text bytes used to compute a header
+// protection mask.
+const SampleSize = aes.BlockSize
+
+// HeaderProtector computes masks hiding the packet header fields which do not
+// need to be readable by the network, in the manner of QUIC header
+// protection.
+type HeaderProtector struct {
+	block cipher.Block
+}
+
+// NewHeaderProtector derives a header protection key from the session secret.
+func NewHeaderProtector(secret []byte) *HeaderProtector {
+	block, err := aes.NewCipher(DeriveKey(secret, "xudp hp", 16))
+	if err != nil {
+		panic(err)
+	}
+	return &HeaderProtector{block: block}
+}
+
+// Mask returns the mask for sample, which must be SampleSize bytes of cipher
+// text taken from the protected packet.
+func (p *HeaderProtector) Mask(sample []byte) []byte {
+	mask := make([]byte, SampleSize)
+	p.block.Encrypt(mask, sample[:SampleSize])
+	return mask
+}
@@ -0,0 +1,19 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// DeriveKey expands secret into a key of the given size bound to label with
+// HKDF-SHA256.
+func DeriveKey(secret []byte, label string, size int) []byte {
+	key := make([]byte, size)
+	r := hkdf.New(sha256.New, secret, nil, []byte(label))
+	if _, err := io.ReadFull(r, key); err != nil {
+		panic(err)
+	}
+	return key
+}
@@ -0,0 +1,137 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// CipherSuite identifies the AEAD used to protect the packets of a session.
+type CipherSuite uint16
+
+const (
+	AES128GCM CipherSuite = iota + 1
+	AES256GCM
+	ChaCha20Poly1305
+)
+
+// DefaultCipherSuites is the preference order used when none is configured.
+var DefaultCipherSuites = []CipherSuite{
+	AES128GCM,
+	AES256GCM,
+	ChaCha20Poly1305,
+}
+
+func (c CipherSuite) String() string {
+	switch c {
+	case AES128GCM:
+		return "AES-128-GCM"
+	case AES256GCM:
+		return "AES-256-GCM"
+	case ChaCha20Poly1305:
+		return "ChaCha20-Poly1305"
+	}
+	return fmt.Sprintf("CipherSuite(%d)", uint16(c))
+}
+
+// Supported reports whether c is implemented by this package.
+func (c CipherSuite) Supported() bool {
+	return c.KeySize() != 0
+}
+
+// KeySize returns the size of the key used by c.
+func (c CipherSuite) KeySize() int {
+	switch c {
+	case AES128GCM:
+		return 16
+	case AES256GCM:
+		return 32
+	case ChaCha20Poly1305:
+		return chacha20poly1305.KeySize
+	}
+	return 0
+}
+
+func (c CipherSuite) aead(key []byte) (cipher.AEAD, error) {
+	switch c {
+	case AES128GCM, AES256GCM:
+		if len(key) != c.KeySize() {
+			return nil, errors.New("crypto: invalid key size")
+		}
+		return newGCM(key)
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	}
+	return nil, fmt.Errorf("crypto: unsupported cipher suite %v", c)
+}
+
+// Encrypt seals plain under key. additional is authenticated but not
+// encrypted, and must be passed unchanged to Decrypt.
+func (c CipherSuite) Encrypt(key, plain, additional []byte) ([]byte, error) {
+	aead, err := c.aead(key)
+	if err != nil {
+		return nil, err
+	}
+	return seal(aead, plain, additional)
+}
+
+// Decrypt opens a cipher text produced by Encrypt.
+func (c CipherSuite) Decrypt(key, cipherText, additional []byte) ([]byte, error) {
+	aead, err := c.aead(key)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, cipherText, additional)
+}
+
+// SelectCipherSuite returns the first suite of preference which is offered
+// by the peer.
+func SelectCipherSuite(preference, offered []CipherSuite) (CipherSuite, bool) {
+	for _, p := range preference {
+		if !p.Supported() {
+			continue
+		}
+		for _, o := range offered {
+			if p == o {
+				return p, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to new cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to new gcm: %w", err)
+	}
+	return gcm, nil
+}
+
+func seal(aead cipher.AEAD, plain, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, additional), nil
+}
+
+func open(aead cipher.AEAD, cipherText, additional []byte) ([]byte, error) {
+	if len(cipherText) < aead.NonceSize() {
+		return nil, errors.New("crypto: invalid cipher text size")
+	}
+	nonce := cipherText[:aead.NonceSize()]
+	ret, err := aead.Open(nil, nonce, cipherText[aead.NonceSize():], additional)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open: %w", err)
+	}
+	return ret, nil
+}
@@ -0,0 +1,31 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCipherSuite(t *testing.T) {
+	for _, suite := range DefaultCipherSuites {
+		key := DeriveKey([]byte("secret"), "test", suite.KeySize())
+		buf, err := suite.Encrypt(key, []byte("hello"), []byte{1})
+		assert.NoError(t, err, suite.String())
+
+		plain, err := suite.Decrypt(key, buf, []byte{1})
+		assert.NoError(t, err, suite.String())
+		assert.Equal(t, []byte("hello"), plain)
+
+		_, err = suite.Decrypt(key, buf, []byte{2})
+		assert.Error(t, err, suite.String())
+	}
+}
+
+func TestSelectCipherSuite(t *testing.T) {
+	suite, ok := SelectCipherSuite(DefaultCipherSuites, []CipherSuite{ChaCha20Poly1305, AES256GCM})
+	assert.True(t, ok)
+	assert.Equal(t, AES256GCM, suite)
+
+	_, ok = SelectCipherSuite([]CipherSuite{AES128GCM}, []CipherSuite{ChaCha20Poly1305})
+	assert.False(t, ok)
+}
@@ -0,0 +1,113 @@
+package xudp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ErrDeadlineExceeded is returned by the methods of Conn and Sess when their
+// deadline or the deadline of their context passed. It is a timeout
+// net.Error and matches context.DeadlineExceeded with errors.Is.
+var ErrDeadlineExceeded error = &deadlineExceededError{}
+
+type deadlineExceededError struct{}
+
+func (e *deadlineExceededError) Error() string   { return "xudp: i/o timeout" }
+func (e *deadlineExceededError) Timeout() bool   { return true }
+func (e *deadlineExceededError) Temporary() bool { return true }
+
+func (e *deadlineExceededError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// contextError returns the error of a done context, ErrDeadlineExceeded in
+// place of context.DeadlineExceeded.
+func contextError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrDeadlineExceeded
+	}
+	return ctx.Err()
+}
+
+// deadline is a channel closed once a deadline passes, which a select can
+// wait on.
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func makeDeadline() deadline {
+	return deadline{cancel: make(chan struct{})}
+}
+
+// set sets the deadline to t. The zero value means no deadline.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // Wait for the timer callback to finish and close cancel.
+	}
+	d.timer = nil
+
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		d.timer = time.AfterFunc(dur, func() {
+			close(d.cancel)
+		})
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel closed once the deadline passes.
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetDeadline sets the read and write deadlines of the session.
+func (s *Sess) SetDeadline(t time.Time) error {
+	s.readDeadline.set(t)
+	s.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for pending and future Receive calls,
+// which return ErrDeadlineExceeded once it passed. The zero value means no
+// deadline.
+func (s *Sess) SetReadDeadline(t time.Time) error {
+	s.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for pending and future Send calls,
+// which return ErrDeadlineExceeded once it passed. A message may have been
+// partially sent. The zero value means no deadline.
+func (s *Sess) SetWriteDeadline(t time.Time) error {
+	s.writeDeadline.set(t)
+	return nil
+}
@@ -0,0 +1,62 @@
+package xudp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSess_ReceiveContext(t *testing.T) {
+	sess := NewSess(nil, nil, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := sess.ReceiveContext(ctx)
+	assert.Equal(t, ErrDeadlineExceeded, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	nerr, ok := err.(net.Error)
+	assert.True(t, ok)
+	assert.True(t, nerr.Timeout())
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = sess.ReceiveContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, context.Canceled, sess.SendContext(ctx, []byte{1}))
+}
+
+func TestSess_SetReadDeadline(t *testing.T) {
+	sess := NewSess(nil, nil, nil)
+	assert.NoError(t, sess.SetReadDeadline(time.Now().Add(-time.Second)))
+	_, err := sess.Receive()
+	assert.Equal(t, ErrDeadlineExceeded, err)
+
+	assert.NoError(t, sess.SetReadDeadline(time.Time{}))
+	sess.incoming <- []byte{1}
+	buf, err := sess.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1}, buf)
+
+	assert.NoError(t, sess.SetDeadline(time.Now().Add(10*time.Millisecond)))
+	_, err = sess.Receive()
+	assert.Equal(t, ErrDeadlineExceeded, err)
+
+	assert.NoError(t, sess.SetWriteDeadline(time.Now().Add(-time.Second)))
+	assert.Equal(t, ErrDeadlineExceeded, sess.Send([]byte{1}))
+}
+
+func TestConn_AcceptContext(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0")
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = ln.AcceptContext(ctx)
+	assert.Equal(t, ErrDeadlineExceeded, err)
+
+	ln.Close()
+	_, err = ln.Accept()
+	assert.Equal(t, ErrConnClosed, err)
+}
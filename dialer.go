@@ -0,0 +1,236 @@
+package xudp
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// aLongTimeAgo is a deadline in the past, which interrupts pending reads.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// Dialer contains options for dialing xudp sessions. The zero value dials
+// with the zero Config.
+type Dialer struct {
+	// Config configures the dialed sessions. It must not be modified
+	// while dialing.
+	Config *Config
+
+	// LocalAddr is the local address to dial from. If nil, a local address
+	// is chosen automatically.
+	LocalAddr net.Addr
+
+	// Control is called after creating the socket and before binding it,
+	// as in net.Dialer.
+	Control func(network, address string, c syscall.RawConn) error
+}
+
+// Dial connects to the xudp server at addr and performs the handshake.
+func (d *Dialer) Dial(network, addr string) (*Sess, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext is like Dial but gives up once ctx is done. The handshake is
+// also bounded by Config.HandshakeTimeout. Once the session is returned, ctx
+// has no effect on it.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (*Sess, error) {
+	return d.dial(ctx, network, addr, nil)
+}
+
+func (d *Dialer) dial(ctx context.Context, network, addr string, early []byte) (*Sess, error) {
+	if len(early) > maxEarlyData {
+		return nil, errors.New("xudp: early data too large")
+	}
+	config := d.Config.clone()
+	ctx, cancel := context.WithTimeout(ctx, config.handshakeTimeout())
+	defer cancel()
+
+	nd := &net.Dialer{
+		LocalAddr: d.LocalAddr,
+		Control:   d.Control,
+	}
+	c, err := nd.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, ok := c.(*net.UDPConn)
+	if !ok {
+		c.Close()
+		return nil, fmt.Errorf("xudp: network %s is not udp", network)
+	}
+	return dialConn(ctx, conn, conn.RemoteAddr(), config, early)
+}
+
+// NewClient performs the handshake with the xudp server at addr over pc and
+// returns the session. Packets received on pc from other addresses are
+// dropped. Closing the session closes pc.
+//
+// NewClient lets xudp run over wrapped sockets, in-memory transports or
+// sockets created with special options.
+func NewClient(pc net.PacketConn, addr net.Addr, config *Config) (*Sess, error) {
+	config = config.clone()
+	ctx, cancel := context.WithTimeout(context.Background(), config.handshakeTimeout())
+	defer cancel()
+	return dialConn(ctx, pc, addr, config, nil)
+}
+
+// dialConn performs the handshake over pc until ctx is done, closing pc if
+// it fails.
+func dialConn(ctx context.Context, pc net.PacketConn, addr net.Addr, config *Config, early []byte) (*Sess, error) {
+	s := newSess(pc, addr, config)
+	s.dialer = true
+	s.setConnectionID(uuid.NewV4().Bytes())
+	if c, ok := pc.(net.Conn); ok && c.RemoteAddr() != nil {
+		s.connected = true
+	}
+	if err := s.dial(ctx, config, early, pc.SetDeadline); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Dial performs the handshake with the xudp server at addr from the socket
+// of the Conn and returns the session, configured by the Config of the
+// Conn. The packets of the session are demultiplexed by ConnectionID along
+// with the ones of the accepted sessions, so a peer can accept and dial
+// sessions on the same port.
+func (c *Conn) Dial(addr net.Addr) (*Sess, error) {
+	return c.DialContext(context.Background(), addr)
+}
+
+// DialContext is like Dial but gives up once ctx is done. The handshake is
+// also bounded by Config.HandshakeTimeout.
+func (c *Conn) DialContext(ctx context.Context, addr net.Addr) (*Sess, error) {
+	select {
+	case <-c.quit:
+		return nil, ErrConnClosed
+	default:
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.config.handshakeTimeout())
+	defer cancel()
+
+	s := c.newSess(addr)
+	s.dialer = true
+	s.inbox = make(chan []byte, c.config.queueSize())
+	s.setConnectionID(uuid.NewV4().Bytes())
+	c.setSess(s.ConnectionID, s)
+	setDeadline := func(t time.Time) error {
+		s.inboxDeadline.set(t)
+		return nil
+	}
+	if err := s.dial(ctx, c.config, nil, setDeadline); err != nil {
+		s.terminate()
+		return nil, err
+	}
+	return s, nil
+}
+
+// dial performs the handshake of a dialed session until ctx is done, and
+// starts it. Handshake failures are returned as a *HandshakeError.
+// setDeadline sets the deadline of the reads of the session, which is used
+// to interrupt them once ctx is done.
+func (s *Sess) dial(ctx context.Context, config *Config, early []byte, setDeadline func(time.Time) error) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = setDeadline(deadline)
+	}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = setDeadline(aLongTimeAgo)
+		case <-stop:
+		}
+	}()
+	err := acceptDial(s, config, early)
+	s.stopRetransmit()
+	close(stop)
+	<-stopped
+	if err != nil {
+		var ne net.Error
+		if ctx.Err() != nil {
+			err = contextError(ctx)
+		} else if errors.As(err, &ne) && ne.Timeout() {
+			// The deadline of the socket passed just before ctx noticed.
+			err = ErrDeadlineExceeded
+		}
+		return &HandshakeError{Stage: s.stage, Err: err}
+	}
+	_ = setDeadline(time.Time{})
+
+	s.established()
+	s.serve()
+	if len(early) > 0 && !s.resumed {
+		return s.Send(early)
+	}
+	return nil
+}
+
+// ListenConfig contains options for listening to xudp sessions.
+type ListenConfig struct {
+	// Config configures the accepted sessions. It must not be modified
+	// afterwards.
+	Config *Config
+
+	// Control is called after creating the socket and before binding it,
+	// as in net.ListenConfig.
+	Control func(network, address string, c syscall.RawConn) error
+}
+
+// Listen announces on the local UDP address addr and accepts sessions.
+func (lc *ListenConfig) Listen(ctx context.Context, network, addr string) (*Conn, error) {
+	nlc := &net.ListenConfig{
+		Control: lc.Control,
+	}
+	pc, err := nlc.ListenPacket(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, ok := pc.(*net.UDPConn)
+	if !ok {
+		pc.Close()
+		return nil, fmt.Errorf("xudp: network %s is not udp", network)
+	}
+	return NewListener(conn, lc.Config)
+}
+
+// NewListener accepts xudp sessions over pc. Closing the Conn closes pc, and
+// the Conn is closed once reading pc fails for good.
+//
+// NewListener lets xudp run over wrapped sockets, in-memory transports or
+// sockets created with special options.
+func NewListener(pc net.PacketConn, config *Config) (*Conn, error) {
+	config = config.clone()
+	ticketKey := config.SessionTicketKey[:]
+	if config.SessionTicketKey == [32]byte{} {
+		ticketKey = make([]byte, 32)
+		if _, err := cryptorand.Read(ticketKey); err != nil {
+			pc.Close()
+			return nil, err
+		}
+	}
+	readBufferSize := config.readBufferSize()
+	c := &Conn{
+		config:    config,
+		ticketKey: ticketKey,
+		conn:      pc,
+		sessions:  sync.Map{},
+		bytePool: sync.Pool{
+			New: func() interface{} {
+				return make([]byte, readBufferSize)
+			},
+		},
+		accepting: make(chan *Sess, config.queueSize()),
+		quit:      make(chan struct{}),
+	}
+	c.listen()
+	return c, nil
+}
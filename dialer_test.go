@@ -0,0 +1,154 @@
+package xudp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialer_DialContext(t *testing.T) {
+	// A server which never answers.
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer conn.Close()
+	addr := conn.LocalAddr().String()
+
+	d := &Dialer{Config: &Config{HandshakeTimeout: 20 * time.Millisecond}}
+	_, err = d.Dial("udp", addr)
+	assert.True(t, errors.Is(err, ErrDeadlineExceeded))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = (&Dialer{}).DialContext(ctx, "udp", addr)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestConfig_Defaults(t *testing.T) {
+	config := &Config{}
+	assert.Equal(t, bufferSize, config.readBufferSize())
+	assert.Equal(t, queueSize, config.queueSize())
+	assert.Equal(t, maxChunkSize, config.chunkSize())
+	assert.Equal(t, uint8(defaultChannel), config.channel())
+
+	config = &Config{ChunkSize: 4096, QueueSize: 1, Channel: 3}
+	assert.Equal(t, maxChunkSize, config.chunkSize())
+	assert.Equal(t, 1, config.queueSize())
+	assert.Equal(t, uint8(3), config.channel())
+}
+
+// packetConn hides the methods of the socket other than net.PacketConn.
+type packetConn struct {
+	net.PacketConn
+}
+
+func TestNewClient(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	ln, err := NewListener(packetConn{pc}, nil)
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	pc, err = net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	client, err := NewClient(packetConn{pc}, ln.Addr(), nil)
+	assert.NoError(t, err)
+	defer client.Close()
+	server, err := ln.Accept()
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.Send([]byte("ping")))
+	buf, err := server.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("ping"), buf)
+
+	assert.NoError(t, server.Send([]byte("pong")))
+	buf, err = client.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("pong"), buf)
+}
+
+// brokenConn fails every read for good, as a closed pipe does.
+type brokenConn struct {
+	net.PacketConn
+
+	reads int32
+}
+
+func (c *brokenConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	atomic.AddInt32(&c.reads, 1)
+	return 0, nil, io.EOF
+}
+
+func TestNewListener_ReadError(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	conn := &brokenConn{PacketConn: pc}
+	ln, err := NewListener(conn, nil)
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = ln.AcceptContext(ctx)
+	assert.Equal(t, ErrConnClosed, err)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&conn.reads))
+}
+
+func TestConn_Dial(t *testing.T) {
+	a, err := Listen("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer a.Close()
+	b, err := Listen("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer b.Close()
+
+	// Each peer dials the other from the port it accepts on.
+	for _, peers := range [][2]*Conn{{a, b}, {b, a}} {
+		client, err := peers[0].Dial(peers[1].Addr())
+		assert.NoError(t, err)
+		server, err := peers[1].Accept()
+		assert.NoError(t, err)
+		assert.Equal(t, peers[0].Addr().String(), server.remoteAddr().String())
+
+		assert.NoError(t, client.Send([]byte("ping")))
+		buf, err := server.Receive()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("ping"), buf)
+		assert.NoError(t, server.Send([]byte("pong")))
+		buf, err = client.Receive()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("pong"), buf)
+
+		assert.NoError(t, client.Close())
+		_, ok := peers[0].getSess(client.ConnectionID)
+		assert.False(t, ok)
+	}
+}
+
+func TestConn_DialContext(t *testing.T) {
+	conn, err := Listen("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+	// A server which never answers.
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer silent.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = conn.DialContext(ctx, silent.LocalAddr())
+	assert.True(t, errors.Is(err, ErrDeadlineExceeded))
+	count := 0
+	conn.sessions.Range(func(_, _ interface{}) bool {
+		count++
+		return true
+	})
+	assert.Equal(t, 0, count)
+}
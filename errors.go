@@ -1 +1,19 @@
 package xudp
+
+import "errors"
+
+var (
+	ErrChecksum    = errors.New("packet: checksum error")
+	ErrShortPacket = errors.New("packet: short packet")
+
+	ErrServerAuthentication = errors.New("xudp: server authentication failed")
+	ErrClientAuthentication = errors.New("xudp: client authentication failed")
+	ErrClientRejected       = errors.New("xudp: client rejected by the server")
+	ErrVersionNegotiation   = errors.New("xudp: no protocol version in common")
+	ErrSessionClosed        = errors.New("xudp: session closed")
+	ErrConnClosed           = errors.New("xudp: use of closed connection")
+	ErrNoConnectionID       = errors.New("xudp: no connection id available")
+
+	errReplayed = errors.New("xudp: replayed packet")
+	errTooOld   = errors.New("xudp: packet outside of replay window")
+)
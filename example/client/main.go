@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"log"
-	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/socketfunc/xudp"
@@ -11,7 +10,7 @@ import (
 )
 
 func main() {
-	sess, err := xudp.Dial("udp4", "localhost:8080")
+	sess, err := xudp.DialWithConfig("udp4", "localhost:8080", &xudp.Config{KeepAlive: true})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -36,15 +35,11 @@ func main() {
 		log.Fatal(err)
 	}
 
-	sess.Keepalive()
-
-	t := time.NewTicker(3 * time.Second)
-	defer t.Stop()
 	for {
-		select {
-		case now := <-t.C:
-			sess.Ping()
-			fmt.Println(now)
+		buf, err := sess.Receive()
+		if err != nil {
+			log.Fatal(err)
 		}
+		fmt.Println(buf)
 	}
 }
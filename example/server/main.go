@@ -16,16 +16,19 @@ func main() {
 	for {
 		sess, err := ln.Accept()
 		if err != nil {
+			// Accept only fails once the Conn is closed.
 			fmt.Println(err)
-			continue
+			return
 		}
 		fmt.Println("Session", sess)
 		go func() {
 			for {
 				buf, err := sess.Receive()
 				if err != nil {
+					// The session is closed, by the client or because it
+					// was idle.
 					fmt.Printf("%v\n", err)
-					continue
+					return
 				}
 				fmt.Println(buf)
 			}
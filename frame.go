@@ -3,6 +3,8 @@ package xudp
 import (
 	"crypto/rand"
 	"encoding/binary"
+
+	"github.com/socketfunc/xudp/crypto"
 )
 
 type Frame interface {
@@ -21,9 +23,50 @@ var (
 	_ Frame = (*PongFrame)(nil)
 	_ Frame = (*ShutdownFrame)(nil)
 	_ Frame = (*ShutAckFrame)(nil)
+	_ Frame = (*AuthFrame)(nil)
+	_ Frame = (*AuthAckFrame)(nil)
+	_ Frame = (*CertificateFrame)(nil)
+	_ Frame = (*ResumeFrame)(nil)
+	_ Frame = (*ResumeAckFrame)(nil)
+	_ Frame = (*TicketFrame)(nil)
+	_ Frame = (*VersionNegotiationFrame)(nil)
+	_ Frame = (*PathChallengeFrame)(nil)
+	_ Frame = (*PathResponseFrame)(nil)
+	_ Frame = (*NewConnectionIDFrame)(nil)
+	_ Frame = (*RetireConnectionIDFrame)(nil)
 )
 
+// frameSizes are the minimum sizes of the frames, which decoders rely on.
+var frameSizes = map[Type]int{
+	Init:               8,
+	InitAck:            20,
+	Session:            84,
+	SessAck:            235,
+	Data:               1070,
+	DataAck:            8,
+	Ping:               4,
+	Pong:               4,
+	Shutdown:           4,
+	ShutAck:            4,
+	Auth:               102,
+	AuthAck:            5,
+	Certificate:        14,
+	Resume:             38,
+	ResumeAck:          74,
+	Ticket:             10,
+	VersionNegotiation: 5,
+	PathChallenge:      12,
+	PathResponse:       12,
+	NewConnectionID:    24,
+	RetireConnectionID: 8,
+}
+
+// DecodeFrame decodes a frame of type typ. It returns nil if typ is unknown
+// or buf is too short for the frame.
 func DecodeFrame(typ Type, buf []byte) Frame {
+	if size, ok := frameSizes[typ]; !ok || len(buf) < size {
+		return nil
+	}
 	switch typ {
 	case Init:
 		return decodeInitFrame(buf)
@@ -45,6 +88,28 @@ func DecodeFrame(typ Type, buf []byte) Frame {
 		return decodeShutdownFrame(buf)
 	case ShutAck:
 		return decodeShutAckFrame(buf)
+	case Auth:
+		return decodeAuthFrame(buf)
+	case AuthAck:
+		return decodeAuthAckFrame(buf)
+	case Certificate:
+		return decodeCertificateFrame(buf)
+	case Resume:
+		return decodeResumeFrame(buf)
+	case ResumeAck:
+		return decodeResumeAckFrame(buf)
+	case Ticket:
+		return decodeTicketFrame(buf)
+	case VersionNegotiation:
+		return decodeVersionNegotiationFrame(buf)
+	case PathChallenge:
+		return decodePathChallengeFrame(buf)
+	case PathResponse:
+		return decodePathResponseFrame(buf)
+	case NewConnectionID:
+		return decodeNewConnectionIDFrame(buf)
+	case RetireConnectionID:
+		return decodeRetireConnectionIDFrame(buf)
 	}
 	return nil
 }
@@ -95,10 +160,20 @@ func decodeInitAckFrame(buf []byte) *InitAckFrame {
 	return frame
 }
 
+// maxPSKIdentitySize is the longest pre-shared key identity a Session frame
+// carries, as its length is encoded in one byte.
+const maxPSKIdentitySize = 255
+
 type SessionFrame struct {
-	StreamID uint32
-	Token    [16]byte
-	Key      [64]byte
+	StreamID     uint32
+	Token        [16]byte
+	Key          [64]byte             // zero in pre-shared key mode without ECDH
+	CipherSuites []crypto.CipherSuite // 1 + 2 * n
+	Nonce        [32]byte
+	PSKIdentity  []byte // 1 + n, empty unless a pre-shared key is used
+	Version      uint32
+	IdleTimeout  uint32   // milliseconds
+	Versions     []uint32 // 1 + 4 * n, the versions the client offered
 }
 
 func (f *SessionFrame) Type() Type {
@@ -106,10 +181,25 @@ func (f *SessionFrame) Type() Type {
 }
 
 func (f *SessionFrame) Bytes() []byte {
-	buf := make([]byte, 84)
+	suites := 85 + 2*len(f.CipherSuites)
+	version := suites + 33 + len(f.PSKIdentity)
+	buf := make([]byte, version+9+4*len(f.Versions))
 	binary.BigEndian.PutUint32(buf[0:4], f.StreamID)
 	copy(buf[4:20], f.Token[:])
 	copy(buf[20:84], f.Key[:])
+	buf[84] = uint8(len(f.CipherSuites))
+	for i, suite := range f.CipherSuites {
+		binary.BigEndian.PutUint16(buf[85+2*i:], uint16(suite))
+	}
+	copy(buf[suites:suites+32], f.Nonce[:])
+	buf[suites+32] = uint8(len(f.PSKIdentity))
+	copy(buf[suites+33:version], f.PSKIdentity)
+	binary.BigEndian.PutUint32(buf[version:version+4], f.Version)
+	binary.BigEndian.PutUint32(buf[version+4:version+8], f.IdleTimeout)
+	buf[version+8] = uint8(len(f.Versions))
+	for i, v := range f.Versions {
+		binary.BigEndian.PutUint32(buf[version+9+4*i:], v)
+	}
 	return buf
 }
 
@@ -118,12 +208,74 @@ func decodeSessionFrame(buf []byte) *SessionFrame {
 	frame.StreamID = binary.BigEndian.Uint32(buf[0:4])
 	copy(frame.Token[:], buf[4:20])
 	copy(frame.Key[:], buf[20:84])
+	if len(buf) <= 84 {
+		return frame
+	}
+	n := int(buf[84])
+	suites := 85 + 2*n
+	if len(buf) < suites {
+		return frame
+	}
+	for i := 0; i < n; i++ {
+		suite := binary.BigEndian.Uint16(buf[85+2*i:])
+		frame.CipherSuites = append(frame.CipherSuites, crypto.CipherSuite(suite))
+	}
+	if len(buf) < suites+33 {
+		return frame
+	}
+	copy(frame.Nonce[:], buf[suites:suites+32])
+	n = int(buf[suites+32])
+	version := suites + 33 + n
+	if len(buf) < version+8 {
+		return frame
+	}
+	if n > 0 {
+		frame.PSKIdentity = append([]byte(nil), buf[suites+33:version]...)
+	}
+	frame.Version = binary.BigEndian.Uint32(buf[version : version+4])
+	frame.IdleTimeout = binary.BigEndian.Uint32(buf[version+4 : version+8])
+	if len(buf) < version+9 {
+		return frame
+	}
+	n = int(buf[version+8])
+	if len(buf) < version+9+4*n {
+		return frame
+	}
+	for i := 0; i < n; i++ {
+		frame.Versions = append(frame.Versions, binary.BigEndian.Uint32(buf[version+9+4*i:]))
+	}
 	return frame
 }
 
+// hasKey reports whether the client sent an ECDH public key.
+func (f *SessionFrame) hasKey() bool {
+	return f.Key != [64]byte{}
+}
+
+const (
+	// flagClientAuth asks the client to send an Auth frame before the
+	// session is accepted, to authenticate or to prove it holds the
+	// pre-shared key it named.
+	flagClientAuth uint8 = 1 << iota
+	// flagCertificate tells the client that Certificate frames follow.
+	flagCertificate
+	// flagPSK tells the client that the pre-shared key it named was found
+	// and mixed into the session secret.
+	flagPSK
+	// flagTicket tells the client that a Ticket frame follows the handshake.
+	flagTicket
+)
+
 type SessAckFrame struct {
-	StreamID uint32
-	Key      [64]byte
+	StreamID    uint32
+	Key         [64]byte // zero in pre-shared key mode without ECDH
+	CipherSuite crypto.CipherSuite
+	Flags       uint8
+	Nonce       [32]byte
+	ServerKey   [32]byte // Ed25519 public key, zero if the server is anonymous
+	IdleTimeout uint32   // milliseconds
+	Signature   [64]byte // Ed25519 signature of the handshake transcript
+	Binder      [32]byte // proof of possession of the pre-shared key
 }
 
 func (f *SessAckFrame) setKey(key []byte) {
@@ -135,16 +287,35 @@ func (f *SessAckFrame) Type() Type {
 }
 
 func (f *SessAckFrame) Bytes() []byte {
-	buf := make([]byte, 68)
+	buf := make([]byte, 235)
 	binary.BigEndian.PutUint32(buf[0:4], f.StreamID)
 	copy(buf[4:68], f.Key[:])
+	binary.BigEndian.PutUint16(buf[68:70], uint16(f.CipherSuite))
+	buf[70] = f.Flags
+	copy(buf[71:103], f.Nonce[:])
+	copy(buf[103:135], f.ServerKey[:])
+	binary.BigEndian.PutUint32(buf[135:139], f.IdleTimeout)
+	copy(buf[139:203], f.Signature[:])
+	copy(buf[203:235], f.Binder[:])
 	return buf
 }
 
+// signed returns the part of the frame covered by the signature and binder.
+func (f *SessAckFrame) signed() []byte {
+	return f.Bytes()[:139]
+}
+
 func decodeSessAckFrame(buf []byte) *SessAckFrame {
 	frame := &SessAckFrame{}
 	frame.StreamID = binary.BigEndian.Uint32(buf[0:4])
 	copy(frame.Key[:], buf[4:68])
+	frame.CipherSuite = crypto.CipherSuite(binary.BigEndian.Uint16(buf[68:70]))
+	frame.Flags = buf[70]
+	copy(frame.Nonce[:], buf[71:103])
+	copy(frame.ServerKey[:], buf[103:135])
+	frame.IdleTimeout = binary.BigEndian.Uint32(buf[135:139])
+	copy(frame.Signature[:], buf[139:203])
+	copy(frame.Binder[:], buf[203:235])
 	return frame
 }
 
@@ -259,8 +430,13 @@ func decodePongFrame(buf []byte) *PongFrame {
 	return frame
 }
 
+// ShutdownFrame closes a session. Application tells whether ErrorCode is a
+// TransportErrorCode or a code defined by the application.
 type ShutdownFrame struct {
-	StreamID uint32
+	StreamID    uint32
+	Application bool
+	ErrorCode   uint32
+	Reason      string // 2 + n
 }
 
 func (f *ShutdownFrame) Type() Type {
@@ -268,14 +444,29 @@ func (f *ShutdownFrame) Type() Type {
 }
 
 func (f *ShutdownFrame) Bytes() []byte {
-	buf := make([]byte, 4)
+	buf := make([]byte, 11+len(f.Reason))
 	binary.BigEndian.PutUint32(buf[0:4], f.StreamID)
+	if f.Application {
+		buf[4] = 1
+	}
+	binary.BigEndian.PutUint32(buf[5:9], f.ErrorCode)
+	binary.BigEndian.PutUint16(buf[9:11], uint16(len(f.Reason)))
+	copy(buf[11:], f.Reason)
 	return buf
 }
 
 func decodeShutdownFrame(buf []byte) *ShutdownFrame {
 	frame := &ShutdownFrame{}
 	frame.StreamID = binary.BigEndian.Uint32(buf[0:4])
+	if len(buf) < 11 {
+		return frame
+	}
+	frame.Application = buf[4] == 1
+	frame.ErrorCode = binary.BigEndian.Uint32(buf[5:9])
+	n := int(binary.BigEndian.Uint16(buf[9:11]))
+	if len(buf) >= 11+n {
+		frame.Reason = string(buf[11 : 11+n])
+	}
 	return frame
 }
 
@@ -298,3 +489,366 @@ func decodeShutAckFrame(buf []byte) *ShutAckFrame {
 	frame.StreamID = binary.BigEndian.Uint32(buf[0:4])
 	return frame
 }
+
+type AuthFrame struct {
+	StreamID   uint32
+	Key        [32]byte // Ed25519 public key, zero if the client only sends a credential
+	Signature  [64]byte // Ed25519 signature of the handshake transcript
+	Credential []byte   // 2 + n
+}
+
+func (f *AuthFrame) Type() Type {
+	return Auth
+}
+
+func (f *AuthFrame) Bytes() []byte {
+	buf := make([]byte, 102+len(f.Credential))
+	binary.BigEndian.PutUint32(buf[0:4], f.StreamID)
+	copy(buf[4:36], f.Key[:])
+	copy(buf[36:100], f.Signature[:])
+	binary.BigEndian.PutUint16(buf[100:102], uint16(len(f.Credential)))
+	copy(buf[102:], f.Credential)
+	return buf
+}
+
+func decodeAuthFrame(buf []byte) *AuthFrame {
+	frame := &AuthFrame{}
+	frame.StreamID = binary.BigEndian.Uint32(buf[0:4])
+	copy(frame.Key[:], buf[4:36])
+	copy(frame.Signature[:], buf[36:100])
+	n := int(binary.BigEndian.Uint16(buf[100:102]))
+	if n > 0 && 102+n <= len(buf) {
+		frame.Credential = append([]byte(nil), buf[102:102+n]...)
+	}
+	return frame
+}
+
+const (
+	AuthAccepted uint8 = iota
+	AuthRejected
+)
+
+type AuthAckFrame struct {
+	StreamID uint32
+	Status   uint8
+}
+
+func (f *AuthAckFrame) Type() Type {
+	return AuthAck
+}
+
+func (f *AuthAckFrame) Bytes() []byte {
+	buf := make([]byte, 5)
+	binary.BigEndian.PutUint32(buf[0:4], f.StreamID)
+	buf[4] = f.Status
+	return buf
+}
+
+func decodeAuthAckFrame(buf []byte) *AuthAckFrame {
+	frame := &AuthAckFrame{}
+	frame.StreamID = binary.BigEndian.Uint32(buf[0:4])
+	frame.Status = buf[4]
+	return frame
+}
+
+// CertificateFrame carries a fragment of the certificate chain and signature
+// of a server, which usually do not fit in a single packet.
+type CertificateFrame struct {
+	StreamID uint32
+	Offset   int
+	Length   int
+	Data     []byte // 2 + n
+}
+
+func (f *CertificateFrame) Type() Type {
+	return Certificate
+}
+
+func (f *CertificateFrame) Bytes() []byte {
+	buf := make([]byte, 14+len(f.Data))
+	binary.BigEndian.PutUint32(buf[0:4], f.StreamID)
+	binary.BigEndian.PutUint32(buf[4:8], uint32(f.Offset))
+	binary.BigEndian.PutUint32(buf[8:12], uint32(f.Length))
+	binary.BigEndian.PutUint16(buf[12:14], uint16(len(f.Data)))
+	copy(buf[14:], f.Data)
+	return buf
+}
+
+func decodeCertificateFrame(buf []byte) *CertificateFrame {
+	frame := &CertificateFrame{}
+	frame.StreamID = binary.BigEndian.Uint32(buf[0:4])
+	frame.Offset = int(binary.BigEndian.Uint32(buf[4:8]))
+	frame.Length = int(binary.BigEndian.Uint32(buf[8:12]))
+	n := int(binary.BigEndian.Uint16(buf[12:14]))
+	if 14+n <= len(buf) {
+		frame.Data = buf[14 : 14+n]
+	}
+	return frame
+}
+
+// ResumeFrame opens a session from a ticket issued by the server in an
+// earlier session, optionally carrying early data encrypted with a key
+// derived from the ticket.
+type ResumeFrame struct {
+	StreamID    uint32
+	Nonce       [32]byte
+	Ticket      []byte // 2 + n
+	EarlyData   []byte // 2 + n
+	Version     uint32
+	IdleTimeout uint32 // milliseconds
+}
+
+func (f *ResumeFrame) Type() Type {
+	return Resume
+}
+
+func (f *ResumeFrame) Bytes() []byte {
+	early := 38 + len(f.Ticket)
+	version := early + 2 + len(f.EarlyData)
+	buf := make([]byte, version+8)
+	binary.BigEndian.PutUint32(buf[0:4], f.StreamID)
+	copy(buf[4:36], f.Nonce[:])
+	binary.BigEndian.PutUint16(buf[36:38], uint16(len(f.Ticket)))
+	copy(buf[38:early], f.Ticket)
+	binary.BigEndian.PutUint16(buf[early:early+2], uint16(len(f.EarlyData)))
+	copy(buf[early+2:version], f.EarlyData)
+	binary.BigEndian.PutUint32(buf[version:version+4], f.Version)
+	binary.BigEndian.PutUint32(buf[version+4:version+8], f.IdleTimeout)
+	return buf
+}
+
+func decodeResumeFrame(buf []byte) *ResumeFrame {
+	frame := &ResumeFrame{}
+	frame.StreamID = binary.BigEndian.Uint32(buf[0:4])
+	copy(frame.Nonce[:], buf[4:36])
+	n := int(binary.BigEndian.Uint16(buf[36:38]))
+	early := 38 + n
+	if len(buf) < early+2 {
+		return frame
+	}
+	frame.Ticket = append([]byte(nil), buf[38:early]...)
+	n = int(binary.BigEndian.Uint16(buf[early : early+2]))
+	version := early + 2 + n
+	if len(buf) < version+8 {
+		return frame
+	}
+	if n > 0 {
+		frame.EarlyData = append([]byte(nil), buf[early+2:version]...)
+	}
+	frame.Version = binary.BigEndian.Uint32(buf[version : version+4])
+	frame.IdleTimeout = binary.BigEndian.Uint32(buf[version+4 : version+8])
+	return frame
+}
+
+const (
+	ResumeAccepted uint8 = iota
+	ResumeRejected
+)
+
+type ResumeAckFrame struct {
+	StreamID    uint32
+	Status      uint8
+	Flags       uint8
+	Nonce       [32]byte
+	IdleTimeout uint32   // milliseconds
+	Binder      [32]byte // proof of possession of the resumption secret
+}
+
+func (f *ResumeAckFrame) Type() Type {
+	return ResumeAck
+}
+
+func (f *ResumeAckFrame) Bytes() []byte {
+	buf := make([]byte, 74)
+	binary.BigEndian.PutUint32(buf[0:4], f.StreamID)
+	buf[4] = f.Status
+	buf[5] = f.Flags
+	copy(buf[6:38], f.Nonce[:])
+	binary.BigEndian.PutUint32(buf[38:42], f.IdleTimeout)
+	copy(buf[42:74], f.Binder[:])
+	return buf
+}
+
+// signed returns the part of the frame covered by the binder.
+func (f *ResumeAckFrame) signed() []byte {
+	return f.Bytes()[:42]
+}
+
+func decodeResumeAckFrame(buf []byte) *ResumeAckFrame {
+	frame := &ResumeAckFrame{}
+	frame.StreamID = binary.BigEndian.Uint32(buf[0:4])
+	frame.Status = buf[4]
+	frame.Flags = buf[5]
+	copy(frame.Nonce[:], buf[6:38])
+	frame.IdleTimeout = binary.BigEndian.Uint32(buf[38:42])
+	copy(frame.Binder[:], buf[42:74])
+	return frame
+}
+
+// TicketFrame carries a resumption ticket, opaque to the client.
+type TicketFrame struct {
+	StreamID uint32
+	Lifetime uint32 // seconds
+	Ticket   []byte // 2 + n
+}
+
+func (f *TicketFrame) Type() Type {
+	return Ticket
+}
+
+func (f *TicketFrame) Bytes() []byte {
+	buf := make([]byte, 10+len(f.Ticket))
+	binary.BigEndian.PutUint32(buf[0:4], f.StreamID)
+	binary.BigEndian.PutUint32(buf[4:8], f.Lifetime)
+	binary.BigEndian.PutUint16(buf[8:10], uint16(len(f.Ticket)))
+	copy(buf[10:], f.Ticket)
+	return buf
+}
+
+func decodeTicketFrame(buf []byte) *TicketFrame {
+	frame := &TicketFrame{}
+	frame.StreamID = binary.BigEndian.Uint32(buf[0:4])
+	frame.Lifetime = binary.BigEndian.Uint32(buf[4:8])
+	n := int(binary.BigEndian.Uint16(buf[8:10]))
+	if len(buf) >= 10+n {
+		frame.Ticket = append([]byte(nil), buf[10:10+n]...)
+	}
+	return frame
+}
+
+// VersionNegotiationFrame answers an Init frame of an unsupported version
+// with the versions the server supports.
+type VersionNegotiationFrame struct {
+	StreamID uint32
+	Versions []uint32 // 1 + 4 * n
+}
+
+func (f *VersionNegotiationFrame) Type() Type {
+	return VersionNegotiation
+}
+
+func (f *VersionNegotiationFrame) Bytes() []byte {
+	buf := make([]byte, 5+4*len(f.Versions))
+	binary.BigEndian.PutUint32(buf[0:4], f.StreamID)
+	buf[4] = uint8(len(f.Versions))
+	for i, version := range f.Versions {
+		binary.BigEndian.PutUint32(buf[5+4*i:], version)
+	}
+	return buf
+}
+
+func decodeVersionNegotiationFrame(buf []byte) *VersionNegotiationFrame {
+	frame := &VersionNegotiationFrame{}
+	frame.StreamID = binary.BigEndian.Uint32(buf[0:4])
+	n := int(buf[4])
+	if len(buf) < 5+4*n {
+		return frame
+	}
+	for i := 0; i < n; i++ {
+		frame.Versions = append(frame.Versions, binary.BigEndian.Uint32(buf[5+4*i:]))
+	}
+	return frame
+}
+
+// PathChallengeFrame asks the peer to prove that it receives the packets
+// sent to a new address, by echoing Data in a PathResponse frame.
+type PathChallengeFrame struct {
+	StreamID uint32
+	Data     [8]byte
+}
+
+func (f *PathChallengeFrame) Type() Type {
+	return PathChallenge
+}
+
+func (f *PathChallengeFrame) Bytes() []byte {
+	buf := make([]byte, 12)
+	binary.BigEndian.PutUint32(buf[0:4], f.StreamID)
+	copy(buf[4:12], f.Data[:])
+	return buf
+}
+
+func decodePathChallengeFrame(buf []byte) *PathChallengeFrame {
+	frame := &PathChallengeFrame{}
+	frame.StreamID = binary.BigEndian.Uint32(buf[0:4])
+	copy(frame.Data[:], buf[4:12])
+	return frame
+}
+
+// PathResponseFrame answers a PathChallenge frame.
+type PathResponseFrame struct {
+	StreamID uint32
+	Data     [8]byte
+}
+
+func (f *PathResponseFrame) Type() Type {
+	return PathResponse
+}
+
+func (f *PathResponseFrame) Bytes() []byte {
+	buf := make([]byte, 12)
+	binary.BigEndian.PutUint32(buf[0:4], f.StreamID)
+	copy(buf[4:12], f.Data[:])
+	return buf
+}
+
+func decodePathResponseFrame(buf []byte) *PathResponseFrame {
+	frame := &PathResponseFrame{}
+	frame.StreamID = binary.BigEndian.Uint32(buf[0:4])
+	copy(frame.Data[:], buf[4:12])
+	return frame
+}
+
+// NewConnectionIDFrame gives the client an alternative connection ID of the
+// session. Sequence is 0 for the connection ID of the handshake and grows
+// with each new one.
+type NewConnectionIDFrame struct {
+	StreamID     uint32
+	Sequence     uint32
+	ConnectionID ConnectionID
+}
+
+func (f *NewConnectionIDFrame) Type() Type {
+	return NewConnectionID
+}
+
+func (f *NewConnectionIDFrame) Bytes() []byte {
+	buf := make([]byte, 24)
+	binary.BigEndian.PutUint32(buf[0:4], f.StreamID)
+	binary.BigEndian.PutUint32(buf[4:8], f.Sequence)
+	copy(buf[8:24], f.ConnectionID[:])
+	return buf
+}
+
+func decodeNewConnectionIDFrame(buf []byte) *NewConnectionIDFrame {
+	frame := &NewConnectionIDFrame{}
+	frame.StreamID = binary.BigEndian.Uint32(buf[0:4])
+	frame.Sequence = binary.BigEndian.Uint32(buf[4:8])
+	copy(frame.ConnectionID[:], buf[8:24])
+	return frame
+}
+
+// RetireConnectionIDFrame tells the server that the client no longer uses
+// the connection ID of Sequence.
+type RetireConnectionIDFrame struct {
+	StreamID uint32
+	Sequence uint32
+}
+
+func (f *RetireConnectionIDFrame) Type() Type {
+	return RetireConnectionID
+}
+
+func (f *RetireConnectionIDFrame) Bytes() []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint32(buf[0:4], f.StreamID)
+	binary.BigEndian.PutUint32(buf[4:8], f.Sequence)
+	return buf
+}
+
+func decodeRetireConnectionIDFrame(buf []byte) *RetireConnectionIDFrame {
+	frame := &RetireConnectionIDFrame{}
+	frame.StreamID = binary.BigEndian.Uint32(buf[0:4])
+	frame.Sequence = binary.BigEndian.Uint32(buf[4:8])
+	return frame
+}
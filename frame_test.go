@@ -3,6 +3,8 @@ package xudp
 import (
 	"fmt"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestInitFrame(t *testing.T) {
@@ -20,3 +22,11 @@ func TestInitAckFrame(t *testing.T) {
 	}
 	fmt.Println(frame.Bytes())
 }
+
+func TestDecodeFrame_Short(t *testing.T) {
+	for typ, size := range frameSizes {
+		assert.Nil(t, DecodeFrame(typ, make([]byte, size-1)), typ.String())
+		assert.NotNil(t, DecodeFrame(typ, make([]byte, size)), typ.String())
+	}
+	assert.Nil(t, DecodeFrame(Type(100), make([]byte, 2048)))
+}
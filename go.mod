@@ -9,7 +9,7 @@ require (
 	github.com/golang/protobuf v1.3.5
 	github.com/satori/go.uuid v1.2.0
 	github.com/stretchr/testify v1.5.1
-	golang.org/x/crypto v0.0.0-20191029031824-8986dd9e96cf // indirect
+	golang.org/x/crypto v0.0.0-20191029031824-8986dd9e96cf
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
 	gopkg.in/yaml.v2 v2.2.4 // indirect
 )
@@ -0,0 +1,138 @@
+package xudp
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+
+	"github.com/socketfunc/xudp/crypto"
+)
+
+const transcriptContext = "xudp handshake transcript"
+
+// handshakeTranscript hashes the handshake messages that the server signs,
+// binding its identity to the ephemeral keys and cipher suite of this
+// connection, and to the versions offered by the client so that the
+// VersionNegotiation frame, which is not authenticated, cannot downgrade it.
+func handshakeTranscript(id ConnectionID, session *SessionFrame, sessAck *SessAckFrame) []byte {
+	h := sha256.New()
+	h.Write([]byte(transcriptContext))
+	h.Write(id[:])
+	h.Write(session.Bytes())
+	h.Write(sessAck.signed())
+	return h.Sum(nil)
+}
+
+func newNonce() [32]byte {
+	var nonce [32]byte
+	if _, err := cryptorand.Read(nonce[:]); err != nil {
+		panic(err)
+	}
+	return nonce
+}
+
+// pskSecret derives the session secret of the pre-shared key mode from the
+// key, the ECDH secret when forward secrecy was asked for, and the nonces of
+// both sides.
+func pskSecret(psk, shared []byte, clientNonce, serverNonce [32]byte) []byte {
+	ikm := make([]byte, 0, len(psk)+len(shared)+64)
+	ikm = append(ikm, psk...)
+	ikm = append(ikm, shared...)
+	ikm = append(ikm, clientNonce[:]...)
+	ikm = append(ikm, serverNonce[:]...)
+	return crypto.DeriveKey(ikm, "xudp psk secret", 32)
+}
+
+// pskBinder proves to the client that the server knows the pre-shared key
+// and derived the same secret.
+func pskBinder(secret, transcript []byte) [32]byte {
+	var binder [32]byte
+	mac := hmac.New(sha256.New, crypto.DeriveKey(secret, "xudp psk binder", 32))
+	mac.Write(transcript)
+	copy(binder[:], mac.Sum(nil))
+	return binder
+}
+
+// signSessAck sets the server key and transcript signature of sessAck.
+func signSessAck(identity ed25519.PrivateKey, id ConnectionID, session *SessionFrame, sessAck *SessAckFrame) {
+	copy(sessAck.ServerKey[:], identity.Public().(ed25519.PublicKey))
+	sessAck.Signature = [64]byte{}
+	sig := ed25519.Sign(identity, handshakeTranscript(id, session, sessAck))
+	copy(sessAck.Signature[:], sig)
+}
+
+// verifyServer checks the server signature of the handshake against the
+// pinned key or verification callback of config. Servers are not
+// authenticated when neither is configured.
+func verifyServer(config *Config, id ConnectionID, session *SessionFrame, sessAck *SessAckFrame) error {
+	if config.ServerKey == nil && config.VerifyServerKey == nil {
+		return nil
+	}
+	key := ed25519.PublicKey(sessAck.ServerKey[:])
+	if bytes.Equal(key, make([]byte, ed25519.PublicKeySize)) {
+		return fmt.Errorf("%w: server sent no identity", ErrServerAuthentication)
+	}
+	if !ed25519.Verify(key, handshakeTranscript(id, session, sessAck), sessAck.Signature[:]) {
+		return fmt.Errorf("%w: invalid handshake signature", ErrServerAuthentication)
+	}
+	if config.ServerKey != nil && !bytes.Equal(key, config.ServerKey) {
+		return fmt.Errorf("%w: server key does not match the pinned key", ErrServerAuthentication)
+	}
+	if config.VerifyServerKey != nil {
+		if err := config.VerifyServerKey(key); err != nil {
+			return fmt.Errorf("%w: %v", ErrServerAuthentication, err)
+		}
+	}
+	return nil
+}
+
+const clientAuthContext = "xudp client authentication"
+
+// ClientIdentity is what a client presented to authenticate itself.
+type ClientIdentity struct {
+	// Key is the long-term public key of the client. It is only set when the
+	// client proved possession of the private key by signing the handshake.
+	Key ed25519.PublicKey
+	// Credential is the opaque credential sent by the client. It is not
+	// verified by xudp and must be checked by the Authenticator.
+	Credential []byte
+}
+
+// newAuthFrame builds the Auth frame a client answers a client
+// authentication request with.
+func newAuthFrame(config *Config, transcript []byte) *AuthFrame {
+	f := &AuthFrame{
+		StreamID:   rand.Uint32(),
+		Credential: config.Credential,
+	}
+	if config.ClientKey != nil {
+		copy(f.Key[:], config.ClientKey.Public().(ed25519.PublicKey))
+		sig := ed25519.Sign(config.ClientKey, append([]byte(clientAuthContext), transcript...))
+		copy(f.Signature[:], sig)
+	}
+	return f
+}
+
+// verifyClient checks the signature of an Auth frame, if any, and returns
+// the identity presented by the client.
+func verifyClient(frame *AuthFrame, transcript []byte) (*ClientIdentity, error) {
+	id := &ClientIdentity{
+		Credential: frame.Credential,
+	}
+	key := ed25519.PublicKey(frame.Key[:])
+	if bytes.Equal(key, make([]byte, ed25519.PublicKeySize)) {
+		if id.Credential == nil {
+			return nil, fmt.Errorf("%w: client sent no identity", ErrClientAuthentication)
+		}
+		return id, nil
+	}
+	if !ed25519.Verify(key, append([]byte(clientAuthContext), transcript...), frame.Signature[:]) {
+		return nil, fmt.Errorf("%w: invalid handshake signature", ErrClientAuthentication)
+	}
+	id.Key = key
+	return id, nil
+}
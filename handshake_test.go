@@ -0,0 +1,233 @@
+package xudp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyServer(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	id := ConnectionID{1, 2, 3}
+	session := &SessionFrame{StreamID: 1}
+	sessAck := &SessAckFrame{StreamID: 2}
+
+	assert.NoError(t, verifyServer(&Config{}, id, session, sessAck))
+	assert.True(t, errors.Is(verifyServer(&Config{ServerKey: public}, id, session, sessAck), ErrServerAuthentication))
+
+	signSessAck(private, id, session, sessAck)
+	assert.NoError(t, verifyServer(&Config{ServerKey: public}, id, session, sessAck))
+	assert.True(t, errors.Is(verifyServer(&Config{ServerKey: other}, id, session, sessAck), ErrServerAuthentication))
+	assert.True(t, errors.Is(verifyServer(&Config{ServerKey: public}, ConnectionID{9}, session, sessAck), ErrServerAuthentication))
+
+	rejected := &Config{VerifyServerKey: func(key ed25519.PublicKey) error {
+		return errors.New("unknown server")
+	}}
+	assert.True(t, errors.Is(verifyServer(rejected, id, session, sessAck), ErrServerAuthentication))
+}
+
+func TestVerifyClient(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	transcript := []byte("transcript")
+
+	frame := newAuthFrame(&Config{ClientKey: private}, transcript)
+	id, err := verifyClient(decodeAuthFrame(frame.Bytes()), transcript)
+	assert.NoError(t, err)
+	assert.Equal(t, private.Public(), id.Key)
+
+	_, err = verifyClient(frame, []byte("other"))
+	assert.True(t, errors.Is(err, ErrClientAuthentication))
+
+	frame = newAuthFrame(&Config{Credential: []byte("token")}, transcript)
+	id, err = verifyClient(decodeAuthFrame(frame.Bytes()), transcript)
+	assert.NoError(t, err)
+	assert.Nil(t, id.Key)
+	assert.Equal(t, []byte("token"), id.Credential)
+
+	_, err = verifyClient(newAuthFrame(&Config{}, transcript), transcript)
+	assert.True(t, errors.Is(err, ErrClientAuthentication))
+}
+
+func TestPSKSecret(t *testing.T) {
+	clientNonce, serverNonce := newNonce(), newNonce()
+	psk := []byte("pre-shared key")
+
+	secret := pskSecret(psk, nil, clientNonce, serverNonce)
+	assert.Equal(t, secret, pskSecret(psk, nil, clientNonce, serverNonce))
+	assert.NotEqual(t, secret, pskSecret(psk, nil, clientNonce, newNonce()))
+	assert.NotEqual(t, secret, pskSecret(psk, []byte("ecdh"), clientNonce, serverNonce))
+	assert.NotEqual(t, secret, pskSecret([]byte("other key"), nil, clientNonce, serverNonce))
+
+	transcript := []byte("transcript")
+	assert.Equal(t, pskBinder(secret, transcript), pskBinder(secret, transcript))
+	assert.NotEqual(t, pskBinder(secret, transcript), pskBinder(secret, []byte("other")))
+}
+
+// assertExchange checks that messages flow both ways between the two ends
+// of a session.
+func assertExchange(t *testing.T, client, server *Sess) {
+	assert.NoError(t, client.Send([]byte("ping")))
+	buf, err := server.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("ping"), buf)
+
+	assert.NoError(t, server.Send([]byte("pong")))
+	buf, err = client.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("pong"), buf)
+}
+
+func TestHandshake_ClientAuth(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	ln, err := ListenWithConfig("127.0.0.1:0", &Config{
+		Authenticator: func(sess *Sess, id *ClientIdentity) error {
+			if !bytes.Equal(id.Key, public) {
+				return errors.New("unknown client")
+			}
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	client, err := DialWithConfig("udp", ln.Addr().String(), &Config{ClientKey: private})
+	assert.NoError(t, err)
+	defer client.Close()
+	server, err := ln.Accept()
+	assert.NoError(t, err)
+	assert.Equal(t, public, server.ClientIdentity().Key)
+	assertExchange(t, client, server)
+
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	_, err = DialWithConfig("udp", ln.Addr().String(), &Config{ClientKey: other})
+	assert.True(t, errors.Is(err, ErrClientRejected))
+	var herr *HandshakeError
+	assert.True(t, errors.As(err, &herr))
+	assert.Equal(t, StageAuth, herr.Stage)
+
+	// A client with no identity is rejected as well.
+	_, err = Dial("udp", ln.Addr().String())
+	assert.True(t, errors.Is(err, ErrClientRejected))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = ln.AcceptContext(ctx)
+	assert.Equal(t, ErrDeadlineExceeded, err)
+}
+
+func TestHandshake_PSK(t *testing.T) {
+	psk := []byte("0123456789abcdef0123456789abcdef")
+	ln, err := ListenWithConfig("127.0.0.1:0", &Config{
+		GetPSK: func(identity []byte) ([]byte, error) {
+			if string(identity) != "client" {
+				return nil, errors.New("unknown identity")
+			}
+			return psk, nil
+		},
+	})
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	for _, forwardSecrecy := range []bool{false, true} {
+		client, err := DialWithConfig("udp", ln.Addr().String(), &Config{
+			PSK:               psk,
+			PSKIdentity:       []byte("client"),
+			PSKForwardSecrecy: forwardSecrecy,
+		})
+		assert.NoError(t, err)
+		server, err := ln.Accept()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("client"), client.PSKIdentity())
+		assert.Equal(t, []byte("client"), server.PSKIdentity())
+		assertExchange(t, client, server)
+		assert.NoError(t, client.Close())
+	}
+
+	// The binder of the server does not match another key. Knowing the
+	// identity is not enough for the session to be accepted either.
+	_, err = DialWithConfig("udp", ln.Addr().String(), &Config{
+		PSK:         []byte("another key"),
+		PSKIdentity: []byte("client"),
+	})
+	assert.True(t, errors.Is(err, ErrServerAuthentication))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = ln.AcceptContext(ctx)
+	assert.Equal(t, ErrDeadlineExceeded, err)
+
+	_, err = DialWithConfig("udp", ln.Addr().String(), &Config{
+		PSK:         psk,
+		PSKIdentity: bytes.Repeat([]byte("a"), maxPSKIdentitySize+1),
+	})
+	assert.Error(t, err)
+}
+
+// forgingConn answers the first Init frame with a VersionNegotiation frame
+// listing versions, as an attacker on the path could.
+type forgingConn struct {
+	net.PacketConn
+	versions []uint32
+	once     sync.Once
+}
+
+func (c *forgingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	h, err := DecodePacketHeader(b)
+	if err == nil && !h.Protected() && h.Type == InitAck {
+		c.once.Do(func() {
+			ack := DecodeFrame(InitAck, b[h.Size():]).(*InitAckFrame)
+			f := &VersionNegotiationFrame{StreamID: ack.StreamID, Versions: c.versions}
+			b = NewPacket(h.ConnectionID[:], h.Sequence, h.Channel, f).Bytes()
+		})
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func TestHandshake_VersionNegotiation(t *testing.T) {
+	defer func(versions []uint32) { SupportedVersions = versions }(SupportedVersions)
+	SupportedVersions = []uint32{2, Version1}
+
+	ln, err := ListenWithConfig("127.0.0.1:0", &Config{Versions: []uint32{Version1}})
+	assert.NoError(t, err)
+	defer ln.Close()
+	client, err := Dial("udp", ln.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+	server, err := ln.Accept()
+	assert.NoError(t, err)
+	assert.Equal(t, Version1, client.Version())
+	assert.Equal(t, Version1, server.Version())
+	assertExchange(t, client, server)
+
+	// A forged VersionNegotiation frame cannot make a client and a server
+	// which both support version 2 use version 1.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	forged, err := NewListener(&forgingConn{PacketConn: pc, versions: []uint32{Version1}}, nil)
+	assert.NoError(t, err)
+	defer forged.Close()
+	_, err = DialWithConfig("udp", forged.Addr().String(), &Config{HandshakeTimeout: 200 * time.Millisecond})
+	var herr *HandshakeError
+	assert.True(t, errors.As(err, &herr))
+	assert.Equal(t, StageSession, herr.Stage)
+	assert.True(t, errors.Is(err, ErrDeadlineExceeded))
+
+	client, err = Dial("udp", forged.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+	assert.Equal(t, uint32(2), client.Version())
+}
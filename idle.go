@@ -0,0 +1,67 @@
+package xudp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const defaultIdleTimeout = 30 * time.Second
+
+// IdleTimeoutError is returned by Send and Receive once a session was closed
+// because no packet was received from the peer for its idle timeout.
+type IdleTimeoutError struct{}
+
+func (e *IdleTimeoutError) Error() string   { return "xudp: session idle timeout" }
+func (e *IdleTimeoutError) Timeout() bool   { return true }
+func (e *IdleTimeoutError) Temporary() bool { return false }
+
+// Is makes errors.Is(err, ErrSessionClosed) true.
+func (e *IdleTimeoutError) Is(target error) bool {
+	return target == ErrSessionClosed
+}
+
+// idleTimeoutMillis encodes an idle timeout for the handshake frames.
+func idleTimeoutMillis(timeout time.Duration) uint32 {
+	return uint32(timeout / time.Millisecond)
+}
+
+// negotiateIdleTimeout sets the idle timeout of the session to the shortest
+// of its own and the one announced by the peer.
+func (s *Sess) negotiateIdleTimeout(remote uint32) {
+	timeout := time.Duration(remote) * time.Millisecond
+	if timeout > 0 && timeout < s.idleTimeout {
+		s.idleTimeout = timeout
+	}
+}
+
+// IdleTimeout returns the idle timeout negotiated in the handshake.
+func (s *Sess) IdleTimeout() time.Duration {
+	return s.idleTimeout
+}
+
+// startIdleTimer closes the session once no packet has been received for
+// its idle timeout.
+func (s *Sess) startIdleTimer() {
+	s.touch()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idleTimer = time.AfterFunc(s.idleTimeout, s.checkIdle)
+}
+
+// touch records that an authenticated packet was received.
+func (s *Sess) touch() {
+	atomic.StoreInt64(&s.lastReceived, time.Now().UnixNano())
+	s.resetKeepalive()
+}
+
+func (s *Sess) checkIdle() {
+	idle := time.Since(time.Unix(0, atomic.LoadInt64(&s.lastReceived)))
+	s.mu.Lock()
+	if idle < s.idleTimeout {
+		s.idleTimer.Reset(s.idleTimeout - idle)
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	s.expire(&IdleTimeoutError{})
+}
@@ -0,0 +1,36 @@
+package xudp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateIdleTimeout(t *testing.T) {
+	sess := NewSess(nil, nil, nil)
+	sess.applyConfig(&Config{})
+	sess.negotiateIdleTimeout(0)
+	assert.Equal(t, defaultIdleTimeout, sess.IdleTimeout())
+	sess.negotiateIdleTimeout(idleTimeoutMillis(time.Minute))
+	assert.Equal(t, defaultIdleTimeout, sess.IdleTimeout())
+	sess.negotiateIdleTimeout(idleTimeoutMillis(time.Second))
+	assert.Equal(t, time.Second, sess.IdleTimeout())
+}
+
+func TestSess_IdleTimeout(t *testing.T) {
+	sess := NewSess(nil, nil, nil)
+	sess.applyConfig(&Config{IdleTimeout: 50 * time.Millisecond})
+	sess.startIdleTimer()
+
+	time.Sleep(30 * time.Millisecond)
+	sess.touch()
+	time.Sleep(30 * time.Millisecond)
+	assert.NoError(t, sess.Send(nil))
+
+	_, err := sess.Receive()
+	assert.Equal(t, &IdleTimeoutError{}, err)
+	assert.True(t, errors.Is(err, ErrSessionClosed))
+	assert.Equal(t, &IdleTimeoutError{}, sess.Send(nil))
+}
@@ -0,0 +1,76 @@
+package xudp
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+const defaultKeepAliveMaxMissed = 3
+
+// KeepAliveTimeoutError is returned by Send and Receive once a session was
+// closed because the peer did not answer its keepalive Pings.
+type KeepAliveTimeoutError struct{}
+
+func (e *KeepAliveTimeoutError) Error() string   { return "xudp: keepalive timeout" }
+func (e *KeepAliveTimeoutError) Timeout() bool   { return true }
+func (e *KeepAliveTimeoutError) Temporary() bool { return false }
+
+// Is makes errors.Is(err, ErrSessionClosed) true.
+func (e *KeepAliveTimeoutError) Is(target error) bool {
+	return target == ErrSessionClosed
+}
+
+// Keepalive turns keepalive on for the session, as Config.KeepAlive does
+// for all sessions. It has no effect if keepalive is already on.
+func (s *Sess) Keepalive() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ticker != nil {
+		return
+	}
+	interval := s.idleTimeout / time.Duration(2*(s.keepAliveMaxMissed+1))
+	s.ticker = time.NewTicker(interval)
+	go s.sendPings(s.ticker, interval)
+}
+
+// sendPings sends a Ping every interval during which nothing else was sent,
+// and closes the session once keepAliveMaxMissed Pings in a row have gone
+// unanswered. An interval without a Ping, because the session was busy,
+// counts as no miss.
+func (s *Sess) sendPings(ticker *time.Ticker, interval time.Duration) {
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.quit:
+			return
+		}
+		s.mu.Lock()
+		if s.pingPending {
+			s.pingPending = false
+			s.missedPings++
+		}
+		dead := s.missedPings >= s.keepAliveMaxMissed
+		s.mu.Unlock()
+		if dead {
+			s.expire(&KeepAliveTimeoutError{})
+			return
+		}
+		if time.Since(time.Unix(0, atomic.LoadInt64(&s.lastSent))) < interval {
+			continue
+		}
+		s.mu.Lock()
+		s.pingPending = true
+		s.mu.Unlock()
+		_ = s.writeFrame(&PingFrame{StreamID: rand.Uint32()})
+	}
+}
+
+// resetKeepalive records that the peer is alive, as any authenticated packet
+// it sends tells, be it the Pong of the last Ping or not.
+func (s *Sess) resetKeepalive() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pingPending = false
+	s.missedPings = 0
+}
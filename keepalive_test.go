@@ -0,0 +1,90 @@
+package xudp
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSess_KeepaliveTimeout(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer conn.Close()
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer peer.Close()
+
+	sess := NewSess(conn, peer.LocalAddr(), make([]byte, 32))
+	sess.applyConfig(&Config{IdleTimeout: time.Second, KeepAlive: true, KeepAliveMaxMissed: 2})
+	sess.established()
+
+	// The peer never answers the Pings.
+	buf := make([]byte, bufferSize)
+	_ = peer.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := peer.Read(buf)
+	assert.NoError(t, err)
+	h, err := DecodePacketHeader(buf[:n])
+	assert.NoError(t, err)
+	assert.Equal(t, Ping, h.Type)
+
+	_, err = sess.Receive()
+	assert.Equal(t, &KeepAliveTimeoutError{}, err)
+}
+
+// pongDroppingConn drops the first Pong packet written.
+type pongDroppingConn struct {
+	net.PacketConn
+
+	dropped int32
+}
+
+func (c *pongDroppingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if h, err := DecodePacketHeader(b); err == nil && h.Type == Pong &&
+		atomic.CompareAndSwapInt32(&c.dropped, 0, 1) {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func TestSess_KeepaliveLostPong(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	conn := &pongDroppingConn{PacketConn: pc}
+	ln, err := NewListener(conn, nil)
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	client, err := DialWithConfig("udp", ln.Addr().String(), &Config{
+		IdleTimeout:        600 * time.Millisecond,
+		KeepAlive:          true,
+		KeepAliveMaxMissed: 2,
+	})
+	assert.NoError(t, err)
+	defer client.Close()
+	server, err := ln.Accept()
+	assert.NoError(t, err)
+
+	// Wait for the Pong of the first Ping to be lost.
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&conn.dropped) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&conn.dropped))
+
+	// The session stays busy, so no other Ping is sent, and the data of
+	// the peer tells it is alive.
+	for start := time.Now(); time.Since(start) < time.Second; {
+		assert.NoError(t, client.Send([]byte("ping")))
+		_, err := server.Receive()
+		assert.NoError(t, err)
+		assert.NoError(t, server.Send([]byte("pong")))
+		_, err = client.Receive()
+		if !assert.NoError(t, err) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
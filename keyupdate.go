@@ -0,0 +1,138 @@
+package xudp
+
+import (
+	"errors"
+
+	"github.com/socketfunc/xudp/crypto"
+)
+
+var errKeyUpdatePending = errors.New("xudp: previous key update not acknowledged by the peer")
+
+// trafficKeys are the packet protection keys of one direction of a session
+// for one key phase.
+type trafficKeys struct {
+	secret []byte
+	key    []byte
+	phase  bool
+}
+
+func newTrafficKeys(secret []byte, label string, suite crypto.CipherSuite) trafficKeys {
+	traffic := crypto.DeriveKey(secret, label, 32)
+	return trafficKeys{
+		secret: traffic,
+		key:    crypto.DeriveKey(traffic, "xudp key", suite.KeySize()),
+	}
+}
+
+// next derives the keys of the following key phase. The previous secret
+// cannot be recovered from them.
+func (k trafficKeys) next(suite crypto.CipherSuite) trafficKeys {
+	secret := crypto.DeriveKey(k.secret, "xudp key update", 32)
+	return trafficKeys{
+		secret: secret,
+		key:    crypto.DeriveKey(secret, "xudp key", suite.KeySize()),
+		phase:  !k.phase,
+	}
+}
+
+// setTrafficKeys derives the keys of both directions from the session
+// secret.
+func (s *Sess) setTrafficKeys(secret []byte) {
+	client := newTrafficKeys(secret, "xudp client traffic", s.suite)
+	server := newTrafficKeys(secret, "xudp server traffic", s.suite)
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	if s.dialer {
+		s.sendKeys, s.recvKeys = client, server
+	} else {
+		s.sendKeys, s.recvKeys = server, client
+	}
+	s.prevRecv = nil
+	s.sentPackets, s.sentBytes = 0, 0
+}
+
+// sendKey returns the key phase and key to protect an outgoing packet of
+// size bytes with, first updating the keys if the configured limits have
+// been reached.
+func (s *Sess) sendKey(size int) (bool, []byte) {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	if s.keyUpdateDue() {
+		// Until the peer follows the previous update, which it can only do
+		// by sending something, the update is pending and reported by
+		// Stats.
+		_ = s.updateKey()
+	}
+	s.sentPackets++
+	s.sentBytes += uint64(size)
+	return s.sendKeys.phase, s.sendKeys.key
+}
+
+func (s *Sess) keyUpdateDue() bool {
+	return s.keyUpdatePackets > 0 && s.sentPackets >= s.keyUpdatePackets ||
+		s.keyUpdateBytes > 0 && s.sentBytes >= s.keyUpdateBytes
+}
+
+// keyUpdatePending reports whether the sending keys are due for an update
+// which waits for the peer to follow the previous one.
+func (s *Sess) keyUpdatePending() bool {
+	return s.keyUpdateDue() && s.sendKeys.phase != s.recvKeys.phase
+}
+
+// recvDecrypt opens a packet, following a key update of the peer when the
+// key phase of the header changes. Packets of the previous phase which
+// arrive late are still accepted, until the packets of the new phase moved
+// the replay window past them.
+func (s *Sess) recvDecrypt(h *PacketHeader, buf []byte) ([]byte, error) {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	ad := h.Bytes()
+	if h.KeyPhase == s.recvKeys.phase {
+		plain, err := s.suite.Decrypt(s.recvKeys.key, buf, ad)
+		if err == nil && s.prevRecv != nil && int32(h.Sequence-s.prevRecvEnd) >= replayWindowSize {
+			s.prevRecv = nil
+		}
+		return plain, err
+	}
+	if s.prevRecv != nil {
+		if plain, err := s.suite.Decrypt(s.prevRecv, buf, ad); err == nil {
+			return plain, nil
+		}
+	}
+	next := s.recvKeys.next(s.suite)
+	plain, err := s.suite.Decrypt(next.key, buf, ad)
+	if err != nil {
+		return nil, err
+	}
+	s.prevRecv = s.recvKeys.key
+	s.prevRecvEnd = h.Sequence
+	s.recvKeys = next
+	if s.sendKeys.phase != next.phase {
+		// The peer initiated the update, follow it.
+		s.sendKeys = s.sendKeys.next(s.suite)
+		s.sentPackets, s.sentBytes = 0, 0
+		s.keyUpdates++
+	}
+	return plain, nil
+}
+
+// updateKey moves the sending direction to the next key phase. A new update
+// is only allowed once the peer has been seen using the current phase.
+func (s *Sess) updateKey() error {
+	if s.sendKeys.phase != s.recvKeys.phase {
+		return errKeyUpdatePending
+	}
+	s.sendKeys = s.sendKeys.next(s.suite)
+	s.sentPackets, s.sentBytes = 0, 0
+	s.keyUpdates++
+	return nil
+}
+
+// UpdateKey derives new keys for the packets sent by this side and flips the
+// key phase, which makes the peer update its keys too. It fails if the peer
+// has not yet followed the previous update.
+func (s *Sess) UpdateKey() error {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	return s.updateKey()
+}
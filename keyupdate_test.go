@@ -0,0 +1,84 @@
+package xudp
+
+import (
+	"testing"
+
+	"github.com/socketfunc/xudp/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func newKeyUpdatePair() (*Sess, *Sess) {
+	secret := make([]byte, 32)
+	client := NewSess(nil, nil, nil)
+	client.dialer = true
+	client.setSecret(secret, crypto.AES128GCM)
+	server := NewSess(nil, nil, nil)
+	server.setSecret(secret, crypto.AES128GCM)
+	return client, server
+}
+
+func transfer(t *testing.T, from, to *Sess) *PacketHeader {
+	buf, err := from.encodeFrame(&PingFrame{StreamID: 1})
+	assert.NoError(t, err)
+	h, err := DecodePacketHeader(buf)
+	assert.NoError(t, err)
+	_, err = to.openPacket(h, buf[h.Size():])
+	assert.NoError(t, err)
+	return h
+}
+
+func TestUpdateKey(t *testing.T) {
+	client, server := newKeyUpdatePair()
+	assert.False(t, transfer(t, client, server).KeyPhase)
+
+	assert.NoError(t, client.UpdateKey())
+	assert.Equal(t, errKeyUpdatePending, client.UpdateKey())
+	assert.True(t, transfer(t, client, server).KeyPhase)
+
+	// The server follows the update of the client.
+	assert.True(t, transfer(t, server, client).KeyPhase)
+	assert.NoError(t, client.UpdateKey())
+	assert.False(t, transfer(t, client, server).KeyPhase)
+
+	assert.Equal(t, uint64(2), client.Stats().KeyUpdates)
+	assert.Equal(t, uint64(2), server.Stats().KeyUpdates)
+}
+
+func TestUpdateKey_LatePacket(t *testing.T) {
+	client, server := newKeyUpdatePair()
+	late, err := client.encodeFrame(&PingFrame{StreamID: 1})
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.UpdateKey())
+	transfer(t, client, server)
+
+	h, err := DecodePacketHeader(late)
+	assert.NoError(t, err)
+	_, err = server.openPacket(h, late[h.Size():])
+	assert.NoError(t, err)
+	assert.NotNil(t, server.prevRecv)
+
+	// The keys of the previous phase are dropped once its packets are
+	// behind the replay window.
+	for i := 0; i < replayWindowSize; i++ {
+		transfer(t, client, server)
+	}
+	assert.Nil(t, server.prevRecv)
+}
+
+func TestUpdateKey_Interval(t *testing.T) {
+	client, server := newKeyUpdatePair()
+	client.applyConfig(&Config{KeyUpdatePackets: 2})
+
+	assert.False(t, transfer(t, client, server).KeyPhase)
+	assert.False(t, transfer(t, client, server).KeyPhase)
+	assert.True(t, transfer(t, client, server).KeyPhase)
+	// Further updates wait for the server to follow.
+	assert.True(t, transfer(t, client, server).KeyPhase)
+	assert.True(t, transfer(t, client, server).KeyPhase)
+	assert.True(t, client.Stats().KeyUpdatePending)
+
+	transfer(t, server, client)
+	assert.False(t, client.Stats().KeyUpdatePending)
+	assert.False(t, transfer(t, client, server).KeyPhase)
+}
@@ -0,0 +1,412 @@
+package xudp
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	_ net.Listener = (*listener)(nil)
+	_ net.Conn     = (*stream)(nil)
+)
+
+const (
+	// segmentHeaderSize is the size of the kind and sequence number which
+	// precede the data of a segment.
+	segmentHeaderSize = 9
+	// streamWindow is the number of segments sent and not yet acknowledged
+	// after which Write blocks, and how far ahead of the next expected one
+	// a segment may be to be kept.
+	streamWindow = 64
+	// maxStreamBuffer is the number of received bytes not read yet above
+	// which new segments are not acknowledged, so that the peer slows down.
+	maxStreamBuffer = 1 << 20
+	// streamTick is how often segments are checked for retransmission.
+	streamTick = 50 * time.Millisecond
+	// fastRetransmitAcks is the number of acknowledgements repeating the
+	// same sequence number after which the segment of that number is sent
+	// again without waiting for its timeout.
+	fastRetransmitAcks = 3
+	// maxStreamLinger bounds the time Close waits for the peer to
+	// acknowledge what was written.
+	maxStreamLinger = 5 * time.Second
+)
+
+const (
+	segmentData uint8 = iota
+	segmentAck
+)
+
+// Addr returns the local address the Conn listens on.
+func (c *Conn) Addr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// NetListener returns c as a net.Listener whose Accept returns the streams
+// of the accepted sessions, for servers written against net.Listener such
+// as net/http or grpc-go.
+func (c *Conn) NetListener() net.Listener {
+	return &listener{conn: c}
+}
+
+type listener struct {
+	conn *Conn
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	sess, err := l.conn.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return sess.NetConn(), nil
+}
+
+func (l *listener) Close() error {
+	l.conn.Close()
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.conn.Addr()
+}
+
+// LocalAddr returns the local address of the session, which is shared by all
+// the sessions accepted by a Conn.
+func (s *Sess) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// NetConn returns the session as a net.Conn carrying a reliable stream of
+// bytes, as a TCP connection does. The bytes written are split into
+// numbered segments which the peer acknowledges. Segments are sent again
+// until then, and are read in order. Read returns io.EOF once the session
+// is closed, whatever the reason.
+//
+// Both ends of the session must use NetConn, and Send and Receive must not
+// be used afterwards. NetConn returns the same net.Conn if called again.
+func (s *Sess) NetConn() net.Conn {
+	s.netConnOnce.Do(func() {
+		s.netConn = newStream(s)
+	})
+	return s.netConn
+}
+
+// segment is a part of the stream sent and not acknowledged yet.
+type segment struct {
+	msg     []byte
+	sent    time.Time
+	timeout time.Duration
+}
+
+type stream struct {
+	sess *Sess
+
+	// writeMu keeps the segments of a Write contiguous.
+	writeMu sync.Mutex
+
+	mu sync.Mutex
+	// changed is closed and replaced whenever Read or Write may proceed.
+	changed  chan struct{}
+	nextSend uint64
+	unacked  map[uint64]*segment
+	lastAck  uint64
+	dupAcks  int
+	nextRecv uint64
+	pending  map[uint64][]byte // segments received ahead of nextRecv
+	buf      []byte            // bytes received in order and not read yet
+	closed   bool
+}
+
+func newStream(sess *Sess) *stream {
+	s := &stream{
+		sess:    sess,
+		changed: make(chan struct{}),
+		unacked: map[uint64]*segment{},
+		pending: map[uint64][]byte{},
+	}
+	go s.receive()
+	go s.retransmit()
+	return s
+}
+
+func encodeSegment(kind uint8, seq uint64, data []byte) []byte {
+	msg := make([]byte, segmentHeaderSize+len(data))
+	msg[0] = kind
+	binary.BigEndian.PutUint64(msg[1:segmentHeaderSize], seq)
+	copy(msg[segmentHeaderSize:], data)
+	return msg
+}
+
+// notify wakes up the Read and Write calls waiting. s.mu must be held.
+func (s *stream) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// receive handles the messages of the session until it is closed.
+func (s *stream) receive() {
+	for {
+		select {
+		case msg := <-s.sess.incoming:
+			s.handle(msg)
+		case <-s.sess.quit:
+			// The messages received before are still read.
+			for {
+				select {
+				case msg := <-s.sess.incoming:
+					s.handle(msg)
+				default:
+					s.mu.Lock()
+					s.closed = true
+					s.notify()
+					s.mu.Unlock()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *stream) handle(msg []byte) {
+	if len(msg) < segmentHeaderSize {
+		return
+	}
+	seq := binary.BigEndian.Uint64(msg[1:segmentHeaderSize])
+	s.mu.Lock()
+	switch msg[0] {
+	case segmentAck:
+		s.acknowledge(seq)
+		return
+	case segmentData:
+	default:
+		s.mu.Unlock()
+		return
+	}
+	switch {
+	case seq < s.nextRecv:
+		// A retransmission of a segment whose acknowledgement was lost.
+	case seq >= s.nextRecv+streamWindow || len(s.buf) >= maxStreamBuffer:
+		s.mu.Unlock()
+		return
+	default:
+		s.pending[seq] = msg[segmentHeaderSize:]
+		for {
+			data, ok := s.pending[s.nextRecv]
+			if !ok {
+				break
+			}
+			delete(s.pending, s.nextRecv)
+			s.buf = append(s.buf, data...)
+			s.nextRecv++
+		}
+		s.notify()
+	}
+	ack := encodeSegment(segmentAck, s.nextRecv, nil)
+	s.mu.Unlock()
+	_ = s.sess.sendMessage(context.Background(), ack, nil)
+}
+
+// acknowledge handles an acknowledgement of the segments before seq. The
+// first segment still missing is sent again once several acknowledgements
+// tell the peer received the ones after it. s.mu must be held, and is
+// released.
+func (s *stream) acknowledge(seq uint64) {
+	for n := range s.unacked {
+		if n < seq {
+			delete(s.unacked, n)
+		}
+	}
+	s.notify()
+	if seq != s.lastAck {
+		s.lastAck, s.dupAcks = seq, 0
+		s.mu.Unlock()
+		return
+	}
+	s.dupAcks++
+	seg, ok := s.unacked[seq]
+	if !ok || s.dupAcks < fastRetransmitAcks {
+		s.mu.Unlock()
+		return
+	}
+	s.dupAcks = 0
+	seg.sent = time.Now()
+	s.mu.Unlock()
+	_ = s.sess.sendMessage(context.Background(), seg.msg, nil)
+}
+
+// retransmit sends the segments again, with exponential backoff, until they
+// are acknowledged.
+func (s *stream) retransmit() {
+	ticker := time.NewTicker(streamTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.sess.quit:
+			return
+		}
+		now := time.Now()
+		var due [][]byte
+		s.mu.Lock()
+		for _, seg := range s.unacked {
+			if now.Sub(seg.sent) < seg.timeout {
+				continue
+			}
+			due = append(due, seg.msg)
+			seg.sent = now
+			if seg.timeout *= 2; seg.timeout > maxRetransmitTimeout {
+				seg.timeout = maxRetransmitTimeout
+			}
+		}
+		s.mu.Unlock()
+		for _, msg := range due {
+			_ = s.sess.sendMessage(context.Background(), msg, nil)
+		}
+	}
+}
+
+func (s *stream) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	for {
+		s.mu.Lock()
+		if len(s.buf) > 0 {
+			n := copy(b, s.buf)
+			if s.buf = s.buf[n:]; len(s.buf) == 0 {
+				s.buf = nil
+			}
+			s.mu.Unlock()
+			return n, nil
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return 0, io.EOF
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-s.sess.readDeadline.wait():
+			return 0, ErrDeadlineExceeded
+		}
+	}
+}
+
+func (s *stream) Write(b []byte) (int, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	n := 0
+	for n < len(b) {
+		// A segment fits in one Data frame, so that losing a packet loses
+		// only the segment it carries.
+		size := len(b) - n
+		if max := s.sess.chunkSize - segmentHeaderSize; size > max {
+			size = max
+		}
+		if err := s.waitWindow(); err != nil {
+			return n, err
+		}
+		s.mu.Lock()
+		seg := &segment{
+			msg:     encodeSegment(segmentData, s.nextSend, b[n:n+size]),
+			sent:    time.Now(),
+			timeout: initialRetransmitTimeout,
+		}
+		s.unacked[s.nextSend] = seg
+		s.nextSend++
+		s.mu.Unlock()
+		// From now on the segment is retransmitted until acknowledged,
+		// so it counts as written.
+		n += size
+		if err := s.sess.sendMessage(context.Background(), seg.msg, nil); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// waitWindow waits until another segment can be sent.
+func (s *stream) waitWindow() error {
+	deadline := s.sess.writeDeadline.wait()
+	for {
+		select {
+		case <-deadline:
+			return ErrDeadlineExceeded
+		default:
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return s.sess.closeError()
+		}
+		if len(s.unacked) < streamWindow {
+			s.mu.Unlock()
+			return nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-deadline:
+			return ErrDeadlineExceeded
+		}
+	}
+}
+
+// Close waits, for maxStreamLinger at most, for the peer to acknowledge
+// what was written, and closes the session.
+func (s *stream) Close() error {
+	s.flush(maxStreamLinger)
+	err := s.sess.Close()
+	if err == errShutdownTimeout {
+		// The session is released anyway.
+		return nil
+	}
+	return err
+}
+
+// flush waits until every segment is acknowledged, the session is closed or
+// timeout elapses.
+func (s *stream) flush(timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		done := s.closed || len(s.unacked) == 0
+		changed := s.changed
+		s.mu.Unlock()
+		if done {
+			return
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+func (s *stream) LocalAddr() net.Addr {
+	return s.sess.LocalAddr()
+}
+
+func (s *stream) RemoteAddr() net.Addr {
+	return s.sess.remoteAddr()
+}
+
+func (s *stream) SetDeadline(t time.Time) error {
+	return s.sess.SetDeadline(t)
+}
+
+func (s *stream) SetReadDeadline(t time.Time) error {
+	return s.sess.SetReadDeadline(t)
+}
+
+func (s *stream) SetWriteDeadline(t time.Time) error {
+	return s.sess.SetWriteDeadline(t)
+}
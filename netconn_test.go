@@ -0,0 +1,77 @@
+package xudp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSess_NetConn(t *testing.T) {
+	// Once the handshake is done, every third packet written by either
+	// side is lost.
+	var lossy int32
+	drop := func(n int) bool { return atomic.LoadInt32(&lossy) != 0 && n%3 == 0 }
+	spc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	ln, err := NewListener(&lossyConn{PacketConn: spc, drop: drop}, nil)
+	assert.NoError(t, err)
+	defer ln.Close()
+	cpc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	client, err := NewClient(&lossyConn{PacketConn: cpc, drop: drop}, ln.Addr(), nil)
+	assert.NoError(t, err)
+	server, err := ln.Accept()
+	assert.NoError(t, err)
+	atomic.StoreInt32(&lossy, 1)
+
+	cconn, sconn := client.NetConn(), server.NetConn()
+	assert.Equal(t, cconn, client.NetConn())
+	data := make([]byte, 256*1024)
+	_, _ = rand.Read(data)
+	go func() {
+		for b := data; len(b) > 0; b = b[len(b)/2+1:] {
+			_, _ = cconn.Write(b[:len(b)/2+1])
+		}
+	}()
+	buf := make([]byte, len(data))
+	assert.NoError(t, sconn.SetReadDeadline(time.Now().Add(10*time.Second)))
+	_, err = io.ReadFull(sconn, buf)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(data, buf))
+
+	n, err := sconn.Write([]byte("bye"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	buf = make([]byte, 3)
+	_, err = io.ReadFull(cconn, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "bye", string(buf))
+
+	atomic.StoreInt32(&lossy, 0)
+	assert.NoError(t, cconn.Close())
+	_, err = sconn.Read(buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestSess_NetConn_Closed(t *testing.T) {
+	sess := NewSess(nil, nil, nil)
+	conn := sess.NetConn()
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, err := conn.Read(make([]byte, 1))
+	assert.True(t, errors.Is(err, ErrDeadlineExceeded))
+
+	// Whatever the reason the session ended for, the stream ends.
+	assert.NoError(t, conn.SetReadDeadline(time.Time{}))
+	sess.expire(&ApplicationError{Code: 1})
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	_, err = conn.Write([]byte("hello"))
+	assert.Error(t, err)
+}
@@ -2,8 +2,9 @@ package xudp
 
 import (
 	"encoding/binary"
-	"errors"
 	"hash/crc32"
+
+	"github.com/socketfunc/xudp/crypto"
 )
 
 type ConnectionID [16]byte
@@ -22,6 +23,17 @@ const (
 	Pong
 	Shutdown
 	ShutAck
+	Auth
+	AuthAck
+	Certificate
+	Resume
+	ResumeAck
+	Ticket
+	VersionNegotiation
+	PathChallenge
+	PathResponse
+	NewConnectionID
+	RetireConnectionID
 )
 
 func (t Type) String() string {
@@ -46,6 +58,28 @@ func (t Type) String() string {
 		return "shutdown"
 	case ShutAck:
 		return "shutack"
+	case Auth:
+		return "auth"
+	case AuthAck:
+		return "authack"
+	case Certificate:
+		return "certificate"
+	case Resume:
+		return "resume"
+	case ResumeAck:
+		return "resumeack"
+	case Ticket:
+		return "ticket"
+	case VersionNegotiation:
+		return "versionnegotiation"
+	case PathChallenge:
+		return "pathchallenge"
+	case PathResponse:
+		return "pathresponse"
+	case NewConnectionID:
+		return "newconnectionid"
+	case RetireConnectionID:
+		return "retireconnectionid"
 	}
 	return ""
 }
@@ -55,21 +89,45 @@ type Packet struct {
 	Frame  Frame
 }
 
+// protectedFlag is set in the type byte of packets whose header is protected.
+// It is never masked, so the receiver knows to remove the protection before
+// reading the type and sequence.
+const protectedFlag = 0x80
+
+// keyPhaseFlag carries the key phase of encrypted packets. It flips each
+// time the sender updates its keys.
+const keyPhaseFlag = 0x40
+
 type PacketHeader struct {
 	checksum     uint32
+	protected    bool
 	Type         Type
 	ConnectionID ConnectionID
 	Sequence     uint32
 	Channel      uint8
+	KeyPhase     bool
+}
+
+// Protected reports whether the type and sequence of the header are masked.
+// They are only meaningful once the protection has been removed with the
+// session keys.
+func (h *PacketHeader) Protected() bool {
+	return h.protected
 }
 
 func (h *PacketHeader) Size() int {
 	return 26
 }
 
+// Bytes returns the header without its checksum. Encrypted packets pass it to
+// the AEAD as additional data, so that the type, connection id, sequence and
+// channel cannot be rewritten without failing decryption.
 func (h *PacketHeader) Bytes() []byte {
 	buf := make([]byte, 22)
 	buf[0] = uint8(h.Type)
+	if h.KeyPhase {
+		buf[0] |= keyPhaseFlag
+	}
 	copy(buf[1:17], h.ConnectionID[:])
 	binary.BigEndian.PutUint32(buf[17:21], h.Sequence)
 	buf[21] = h.Channel
@@ -134,22 +192,61 @@ func checksum(buf []byte) uint32 {
 }
 
 func CheckPacket(buf []byte) error {
+	if len(buf) < 26 {
+		return ErrShortPacket
+	}
 	c := binary.BigEndian.Uint32(buf[0:4])
 	if c != checksum(buf[4:]) {
-		return errors.New("packet: checksum error")
+		return ErrChecksum
 	}
 	return nil
 }
 
 func DecodePacketHeader(buf []byte) (*PacketHeader, error) {
+	if err := CheckPacket(buf); err != nil {
+		return nil, err
+	}
 	h := &PacketHeader{}
 	h.checksum = binary.BigEndian.Uint32(buf[0:4])
-	if h.checksum != checksum(buf[4:]) {
-		//return nil, errors.New("packet: checksum error")
-	}
-	h.Type = Type(buf[4])
+	h.protected = buf[4]&protectedFlag != 0
+	h.Type = Type(buf[4] &^ (protectedFlag | keyPhaseFlag))
+	h.KeyPhase = buf[4]&keyPhaseFlag != 0
 	copy(h.ConnectionID[:], buf[5:21])
 	h.Sequence = binary.BigEndian.Uint32(buf[21:25])
 	h.Channel = buf[25]
 	return h, nil
 }
+
+// protectHeader masks the type, key phase and sequence of an encoded packet with a mask
+// computed from a sample of its cipher text, then updates the checksum.
+func protectHeader(p *crypto.HeaderProtector, buf []byte) error {
+	if len(buf) < 26+crypto.SampleSize {
+		return ErrShortPacket
+	}
+	mask := p.Mask(buf[26 : 26+crypto.SampleSize])
+	buf[4] = protectedFlag | (buf[4]^mask[0])&^protectedFlag
+	for i := 0; i < 4; i++ {
+		buf[21+i] ^= mask[1+i]
+	}
+	binary.BigEndian.PutUint32(buf[0:4], checksum(buf[4:]))
+	return nil
+}
+
+// unprotectHeader removes the header protection of buf and updates h with
+// the real type and sequence.
+func unprotectHeader(p *crypto.HeaderProtector, h *PacketHeader, buf []byte) error {
+	if len(buf) < 26+crypto.SampleSize {
+		return ErrShortPacket
+	}
+	mask := p.Mask(buf[26 : 26+crypto.SampleSize])
+	typ := (buf[4] ^ mask[0]) &^ protectedFlag
+	h.Type = Type(typ &^ keyPhaseFlag)
+	h.KeyPhase = typ&keyPhaseFlag != 0
+	seq := make([]byte, 4)
+	for i := 0; i < 4; i++ {
+		seq[i] = buf[21+i] ^ mask[1+i]
+	}
+	h.Sequence = binary.BigEndian.Uint32(seq)
+	h.protected = false
+	return nil
+}
@@ -3,6 +3,9 @@ package xudp
 import (
 	"fmt"
 	"testing"
+
+	"github.com/socketfunc/xudp/crypto"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDecodePacket(t *testing.T) {
@@ -36,3 +39,34 @@ func BenchmarkPacket_Bytes(b *testing.B) {
 		p.Bytes()
 	}
 }
+
+func TestDecodePacketHeader_Checksum(t *testing.T) {
+	id := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6}
+	buf := NewPacket(id, 1, 1, &InitFrame{StreamID: 1, Version: 1}).Bytes()
+	buf[25] = 2
+
+	_, err := DecodePacketHeader(buf)
+	assert.Equal(t, ErrChecksum, err)
+
+	_, err = DecodePacketHeader(buf[:10])
+	assert.Equal(t, ErrShortPacket, err)
+}
+
+func TestProtectHeader(t *testing.T) {
+	hp := crypto.NewHeaderProtector(make([]byte, 32))
+	h := NewPacketHeader(Data, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6}, 100, 1)
+	h.KeyPhase = true
+	buf := Payload(h, make([]byte, 32))
+
+	assert.NoError(t, protectHeader(hp, buf))
+	decoded, err := DecodePacketHeader(buf)
+	assert.NoError(t, err)
+	assert.True(t, decoded.Protected())
+	assert.Equal(t, h.ConnectionID, decoded.ConnectionID)
+
+	assert.NoError(t, unprotectHeader(hp, decoded, buf))
+	assert.False(t, decoded.Protected())
+	assert.Equal(t, Data, decoded.Type)
+	assert.Equal(t, uint32(100), decoded.Sequence)
+	assert.True(t, decoded.KeyPhase)
+}
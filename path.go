@@ -0,0 +1,63 @@
+package xudp
+
+import (
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// pathChallengeInterval is the minimum time between two PathChallenge
+// frames of a session.
+const pathChallengeInterval = 200 * time.Millisecond
+
+// pathValidation is the validation of a new address of the peer. It is only
+// used by the loop of the Conn which accepted the session.
+type pathValidation struct {
+	addr net.Addr
+	data [8]byte
+	sent time.Time
+}
+
+// challengePath is called for the authenticated packets of an accepted
+// session. Packets from a new address are processed, but the session keeps
+// sending to the validated address until the new one answers a
+// PathChallenge frame. Challenges are rate limited, so that spoofed packets
+// cannot make the server flood an address, and a peer which comes back to
+// its previous address is validated again the same way.
+func (s *Sess) challengePath(addr net.Addr) error {
+	if addr.String() == s.remoteAddr().String() {
+		return nil
+	}
+	now := time.Now()
+	if now.Sub(s.path.sent) < pathChallengeInterval {
+		return nil
+	}
+	if s.path.addr == nil || s.path.addr.String() != addr.String() {
+		s.path.addr = addr
+		if _, err := cryptorand.Read(s.path.data[:]); err != nil {
+			return err
+		}
+	}
+	s.path.sent = now
+	buf, err := s.encodeFrame(&PathChallengeFrame{StreamID: rand.Uint32(), Data: s.path.data})
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.WriteTo(buf, addr)
+	return err
+}
+
+// validatePath moves the session to addr if frame, received from addr,
+// answers the challenge sent there.
+func (s *Sess) validatePath(addr net.Addr, frame *PathResponseFrame) {
+	if s.path.addr == nil || s.path.addr.String() != addr.String() ||
+		!hmac.Equal(s.path.data[:], frame.Data[:]) {
+		return
+	}
+	s.setRemoteAddr(addr)
+	s.path = pathValidation{}
+	atomic.AddUint64(&s.migrations, 1)
+}
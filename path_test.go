@@ -0,0 +1,96 @@
+package xudp
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rebindingConn simulates a NAT rebinding by moving to a new socket.
+type rebindingConn struct {
+	mu sync.Mutex
+	net.PacketConn
+}
+
+func (c *rebindingConn) current() net.PacketConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.PacketConn
+}
+
+func (c *rebindingConn) rebind(pc net.PacketConn) {
+	c.mu.Lock()
+	old := c.PacketConn
+	c.PacketConn = pc
+	c.mu.Unlock()
+	old.Close()
+}
+
+func (c *rebindingConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		pc := c.current()
+		n, addr, err := pc.ReadFrom(b)
+		if err != nil && pc != c.current() {
+			continue
+		}
+		return n, addr, err
+	}
+}
+
+func (c *rebindingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.current().WriteTo(b, addr)
+}
+
+func TestSess_Migration(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	conn := &rebindingConn{PacketConn: pc}
+	client, err := NewClient(conn, ln.Addr(), nil)
+	assert.NoError(t, err)
+	defer client.Close()
+	server, err := ln.Accept()
+	assert.NoError(t, err)
+
+	pc, err = net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	conn.rebind(pc)
+
+	// The packet from the new address is received, and the server moves to
+	// it once the client answered its challenge.
+	assert.NoError(t, client.Send([]byte("moved")))
+	buf, err := server.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("moved"), buf)
+	deadline := time.Now().Add(time.Second)
+	for server.Stats().Migrations == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, uint64(1), server.Stats().Migrations)
+	assert.Equal(t, pc.LocalAddr().String(), server.remoteAddr().String())
+
+	assert.NoError(t, server.Send([]byte("welcome")))
+	buf, err = client.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("welcome"), buf)
+}
+
+func TestSess_ValidatePath(t *testing.T) {
+	old := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+	sess := NewSess(nil, old, nil)
+	sess.path = pathValidation{addr: addr, data: [8]byte{1, 2, 3}}
+
+	sess.validatePath(addr, &PathResponseFrame{Data: [8]byte{1, 2, 4}})
+	assert.Equal(t, old, sess.remoteAddr())
+	sess.validatePath(old, &PathResponseFrame{Data: [8]byte{1, 2, 3}})
+	assert.Equal(t, old, sess.remoteAddr())
+	sess.validatePath(addr, &PathResponseFrame{Data: [8]byte{1, 2, 3}})
+	assert.Equal(t, addr, sess.remoteAddr())
+	assert.Equal(t, uint64(1), sess.Stats().Migrations)
+}
@@ -0,0 +1,76 @@
+package xudp
+
+import (
+	"sync"
+)
+
+const replayWindowSize = 64
+
+// replayWindow tracks the sequence numbers recently received from the peer
+// and rejects duplicates and packets older than the window. Comparisons are
+// done on the signed distance between sequences so the window keeps working
+// when the 32 bit sequence wraps around.
+type replayWindow struct {
+	mu     sync.Mutex
+	init   bool
+	top    uint32
+	bitmap uint64
+}
+
+// check reports whether seq would be accepted without recording it.
+func (w *replayWindow) check(seq uint32) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.check0(seq)
+}
+
+func (w *replayWindow) check0(seq uint32) error {
+	if !w.init {
+		return nil
+	}
+	diff := int32(seq - w.top)
+	if diff > 0 {
+		return nil
+	}
+	offset := uint32(-diff)
+	if offset >= replayWindowSize {
+		return errTooOld
+	}
+	if w.bitmap&(1<<offset) != 0 {
+		return errReplayed
+	}
+	return nil
+}
+
+// update records seq as received. It must only be called once the packet has
+// been authenticated.
+func (w *replayWindow) update(seq uint32) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.check0(seq); err != nil {
+		return err
+	}
+	if !w.init {
+		w.init = true
+		w.top = seq
+		w.bitmap = 1
+		return nil
+	}
+	diff := int32(seq - w.top)
+	if diff > 0 {
+		if diff >= replayWindowSize {
+			w.bitmap = 0
+		} else {
+			w.bitmap <<= uint(diff)
+		}
+		w.bitmap |= 1
+		w.top = seq
+		return nil
+	}
+	w.bitmap |= 1 << uint32(-diff)
+	return nil
+}
+
+func isReplay(err error) bool {
+	return err == errReplayed || err == errTooOld
+}
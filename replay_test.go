@@ -0,0 +1,31 @@
+package xudp
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayWindow(t *testing.T) {
+	w := &replayWindow{}
+	assert.NoError(t, w.update(100))
+	assert.NoError(t, w.update(102))
+	assert.NoError(t, w.update(101))
+	assert.Equal(t, errReplayed, w.update(101))
+	assert.Equal(t, errReplayed, w.check(102))
+
+	assert.NoError(t, w.update(100+replayWindowSize))
+	assert.Equal(t, errTooOld, w.check(100))
+	assert.NoError(t, w.check(101+replayWindowSize))
+}
+
+func TestReplayWindow_Wraparound(t *testing.T) {
+	w := &replayWindow{}
+	assert.NoError(t, w.update(math.MaxUint32-1))
+	assert.NoError(t, w.update(1))
+	assert.NoError(t, w.update(math.MaxUint32))
+	assert.NoError(t, w.update(0))
+	assert.Equal(t, errReplayed, w.check(math.MaxUint32))
+	assert.Equal(t, errReplayed, w.check(0))
+}
@@ -0,0 +1,140 @@
+package xudp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	initialRetransmitTimeout = 200 * time.Millisecond
+	maxRetransmitTimeout     = 3 * time.Second
+)
+
+// HandshakeStage is a stage of the handshake of a dialed session.
+type HandshakeStage uint8
+
+const (
+	StageInit HandshakeStage = iota
+	StageSession
+	StageCertificate
+	StageAuth
+	StageTicket
+	StageResume
+)
+
+func (s HandshakeStage) String() string {
+	switch s {
+	case StageInit:
+		return "init"
+	case StageSession:
+		return "session"
+	case StageCertificate:
+		return "certificate"
+	case StageAuth:
+		return "auth"
+	case StageTicket:
+		return "ticket"
+	case StageResume:
+		return "resume"
+	}
+	return fmt.Sprintf("stage(%d)", uint8(s))
+}
+
+// HandshakeError is returned when dialing a session fails during the
+// handshake. Err is ErrDeadlineExceeded if the handshake timed out.
+type HandshakeError struct {
+	Stage HandshakeStage
+	Err   error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("xudp: handshake failed during %v: %v", e.Stage, e.Err)
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *HandshakeError) Timeout() bool {
+	var ne net.Error
+	return errors.As(e.Err, &ne) && ne.Timeout()
+}
+
+func (e *HandshakeError) Temporary() bool { return false }
+
+// retransmitter sends the last flight of the client handshake again, with
+// exponential backoff, until the next one is sent or it is stopped. A lost
+// flight or a lost answer of the server then does not stall the handshake.
+type retransmitter struct {
+	mu      sync.Mutex
+	send    func() error
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+// sendFlight sends a flight of the handshake and retransmits it until the
+// next flight. send is called for each transmission, so that encrypted
+// frames are not dropped as replays.
+func (s *Sess) sendFlight(send func() error) error {
+	r := &s.rtx
+	r.mu.Lock()
+	r.send = send
+	r.timeout = initialRetransmitTimeout
+	if r.timer == nil {
+		r.timer = time.AfterFunc(r.timeout, s.retransmit)
+	} else {
+		r.timer.Reset(r.timeout)
+	}
+	r.mu.Unlock()
+	return send()
+}
+
+func (s *Sess) retransmit() {
+	r := &s.rtx
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.send == nil {
+		return
+	}
+	_ = r.send()
+	if r.timeout *= 2; r.timeout > maxRetransmitTimeout {
+		r.timeout = maxRetransmitTimeout
+	}
+	r.timer.Reset(r.timeout)
+}
+
+// stopRetransmit stops retransmitting once the handshake is over.
+func (s *Sess) stopRetransmit() {
+	r := &s.rtx
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.send = nil
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+}
+
+// awaitFrame reads packets until a frame of one of types arrives during the
+// given stage of the handshake. Packets which fail to decode, and frames of
+// other types such as the answers to retransmissions, are ignored.
+func (s *Sess) awaitFrame(stage HandshakeStage, types ...Type) (Frame, error) {
+	s.stage = stage
+	for {
+		buf, err := s.read()
+		if err != nil {
+			return nil, err
+		}
+		_, frame, err := s.decodePacket(buf)
+		if err != nil || frame == nil {
+			continue
+		}
+		for _, typ := range types {
+			if frame.Type() == typ {
+				return frame, nil
+			}
+		}
+	}
+}
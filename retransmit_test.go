@@ -0,0 +1,71 @@
+package xudp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lossyConn drops the packets written while drop returns true.
+type lossyConn struct {
+	net.PacketConn
+
+	mu     sync.Mutex
+	writes int
+	drop   func(n int) bool
+}
+
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	c.writes++
+	drop := c.drop(c.writes)
+	c.mu.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func TestHandshake_Retransmit(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	// Drop the first Init and Session packets, and send garbage which must
+	// be ignored.
+	_, _ = pc.WriteTo([]byte("stray"), pc.LocalAddr())
+	conn := &lossyConn{PacketConn: pc, drop: func(n int) bool { return n == 1 || n == 3 }}
+	start := time.Now()
+	client, err := NewClient(conn, ln.Addr(), &Config{HandshakeTimeout: 2 * time.Second})
+	assert.NoError(t, err)
+	defer client.Close()
+	assert.True(t, time.Since(start) >= 2*initialRetransmitTimeout)
+
+	server, err := ln.Accept()
+	assert.NoError(t, err)
+	assert.NoError(t, client.Send([]byte("ping")))
+	buf, err := server.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("ping"), buf)
+}
+
+func TestHandshakeError(t *testing.T) {
+	// A server which never answers.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer pc.Close()
+
+	d := &Dialer{Config: &Config{HandshakeTimeout: 50 * time.Millisecond}}
+	_, err = d.Dial("udp", pc.LocalAddr().String())
+	var herr *HandshakeError
+	assert.True(t, errors.As(err, &herr))
+	assert.Equal(t, StageInit, herr.Stage)
+	assert.True(t, herr.Timeout())
+	assert.True(t, errors.Is(err, ErrDeadlineExceeded))
+}
@@ -1,11 +1,16 @@
 package xudp
 
 import (
+	"context"
 	"crypto/sha256"
-	"fmt"
+	"crypto/x509"
+	"errors"
 	"log"
 	"math/rand"
 	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/DataDog/zstd"
@@ -20,117 +25,514 @@ const (
 
 type Sess struct {
 	dialer bool
+	// connected is set when conn is connected to addr, as the sockets
+	// created by a Dialer are.
+	connected bool
+	// inbox receives the packets of a session dialed by a Conn, which
+	// reads its socket.
+	inbox         chan []byte
+	inboxDeadline deadline
+	// rtx and stage are only used during the handshake of a dialed session.
+	rtx   retransmitter
+	stage HandshakeStage
 
+	addrMu      sync.Mutex
 	addr        net.Addr
-	conn        *net.UDPConn
+	conn        net.PacketConn
 	incoming    chan []byte
 	acknowledge chan []byte
 	quit        chan struct{}
 	ticker      *time.Ticker
 
+	readBufferSize   int
+	chunkSize        int
+	compressionLevel int
+	channel          uint8
+	closeTimeout     time.Duration
+
+	// server is the Conn which accepted or dialed the session, nil if the
+	// session has its own socket.
+	server   *Conn
+	mu       sync.Mutex
+	closing  bool
+	closeErr error
+
+	readDeadline  deadline
+	writeDeadline deadline
+
+	idleTimeout  time.Duration
+	idleTimer    *time.Timer
+	lastReceived int64
+
+	keepAlive          bool
+	keepAliveMaxMissed int
+	pingPending        bool
+	missedPings        int
+	lastSent           int64
+	sending            sync.WaitGroup
+	shutAck            chan struct{}
+	closeOnce          sync.Once
+
 	private   *crypto.PrivateKey
 	public    *crypto.PublicKey
 	secretKey []byte
+	suite     crypto.CipherSuite
+	hp        *crypto.HeaderProtector
+	protect   bool
+	version   uint32
+
+	keyMu            sync.Mutex
+	sendKeys         trafficKeys
+	recvKeys         trafficKeys
+	prevRecv         []byte
+	prevRecvEnd      uint32 // the sequence which ended the previous phase
+	sentPackets      uint64
+	sentBytes        uint64
+	keyUpdatePackets uint64
+	keyUpdateBytes   uint64
+	keyUpdates       uint64
 
 	ConnectionID ConnectionID
 	Sequence     uint32
 	tempData     map[uint32]*buffer.Buffer
 	data         map[uint32]*buffer.Buffer
+
+	// transcript, pending and identity are only used by the server while
+	// and after authenticating the client.
+	transcript []byte
+	pending    bool
+	identity   *ClientIdentity
+
+	peerCertificates []*x509.Certificate
+	pskIdentity      []byte
+	resumed          bool
+	ticket           *SessionTicket
+
+	replay   replayWindow
+	replayed uint64
+	tooOld   uint64
+	dropped  uint64
+
+	path       pathValidation
+	migrations uint64
+
+	netConn     *stream
+	netConnOnce sync.Once
+
+	// cidMu guards the connection IDs of the session. ConnectionID is the
+	// one of the handshake, of sequence 0, and sendID the one of the
+	// packets sent.
+	cidMu         sync.Mutex
+	sendID        ConnectionID
+	sendIDSeq     uint32
+	connectionIDs map[uint32]ConnectionID // active connection IDs by sequence
+	nextIDSeq     uint32
 }
 
-func NewSess(conn *net.UDPConn, addr net.Addr, secret []byte) *Sess {
+// Stats holds counters of a session.
+type Stats struct {
+	// Replayed is the number of packets dropped because their sequence had
+	// already been received.
+	Replayed uint64
+	// TooOld is the number of packets dropped because their sequence was
+	// behind the replay window.
+	TooOld uint64
+	// KeyUpdates is the number of times the sending keys were updated.
+	KeyUpdates uint64
+	// KeyUpdatePending is set when the sending keys reached the limits of
+	// the Config but are not updated until the peer follows the previous
+	// update, which it does once it sends a packet.
+	KeyUpdatePending bool
+	// Migrations is the number of times the peer moved to a new address
+	// which was validated.
+	Migrations uint64
+	// Dropped is the number of messages dropped because the queue of
+	// received messages was full.
+	Dropped uint64
+}
+
+func NewSess(conn net.PacketConn, addr net.Addr, secret []byte) *Sess {
+	sess := newSess(conn, addr, &Config{})
+	if secret != nil {
+		sess.setSecret(secret, crypto.DefaultCipherSuites[0])
+	}
+	return sess
+}
+
+func newSess(conn net.PacketConn, addr net.Addr, config *Config) *Sess {
 	sess := &Sess{
-		addr:        addr,
-		conn:        conn,
-		incoming:    make(chan []byte, queueSize),
-		acknowledge: make(chan []byte, queueSize),
-		quit:        make(chan struct{}, 1),
-		secretKey:   secret,
-		Sequence:    rand.Uint32(),
-		data:        map[uint32]*buffer.Buffer{},
+		addr:          addr,
+		conn:          conn,
+		incoming:      make(chan []byte, config.queueSize()),
+		acknowledge:   make(chan []byte, config.queueSize()),
+		quit:          make(chan struct{}),
+		shutAck:       make(chan struct{}, 1),
+		Sequence:      rand.Uint32(),
+		data:          map[uint32]*buffer.Buffer{},
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+		inboxDeadline: makeDeadline(),
 	}
+	sess.applyConfig(config)
 	return sess
 }
 
-func (s *Sess) Keepalive() {
+// applyConfig sets the options of config which apply to established
+// sessions.
+func (s *Sess) applyConfig(config *Config) {
+	s.readBufferSize = config.readBufferSize()
+	s.chunkSize = config.chunkSize()
+	s.compressionLevel = config.compressionLevel()
+	s.channel = config.channel()
+	s.closeTimeout = config.closeTimeout()
+	s.protect = config.HeaderProtection
+	s.keyUpdatePackets = config.KeyUpdatePackets
+	s.keyUpdateBytes = config.KeyUpdateBytes
+	s.idleTimeout = config.idleTimeout()
+	s.keepAlive = config.KeepAlive
+	s.keepAliveMaxMissed = config.keepAliveMaxMissed()
+}
+
+// established starts the timers of a session once its handshake completed.
+func (s *Sess) established() {
+	s.startIdleTimer()
+	if s.keepAlive {
+		s.Keepalive()
+	}
+}
+
+// setSecret derives the packet protection keys of the negotiated cipher
+// suite from the shared secret of the handshake.
+func (s *Sess) setSecret(secret []byte, suite crypto.CipherSuite) {
+	s.secretKey = secret
+	s.suite = suite
+	s.setTrafficKeys(secret)
+	s.hp = crypto.NewHeaderProtector(secret)
+}
+
+// ClientIdentity returns the identity the client authenticated with, or nil
+// if the server did not require client authentication.
+func (s *Sess) ClientIdentity() *ClientIdentity {
+	return s.identity
+}
+
+// PeerCertificates returns the verified certificate chain of the server,
+// leaf first. It is nil unless the server authenticated with a certificate.
+func (s *Sess) PeerCertificates() []*x509.Certificate {
+	return s.peerCertificates
+}
+
+// PSKIdentity returns the identity of the pre-shared key the session was
+// established with, or nil.
+func (s *Sess) PSKIdentity() []byte {
+	return s.pskIdentity
+}
+
+// Resumed reports whether the session was resumed from a ticket.
+func (s *Sess) Resumed() bool {
+	return s.resumed
+}
+
+// SessionTicket returns the ticket the server issued for this session, to
+// be set in Config.SessionTicket to resume later. It is nil on the server or
+// if the server did not issue one.
+func (s *Sess) SessionTicket() *SessionTicket {
+	return s.ticket
+}
+
+// CipherSuite returns the cipher suite negotiated in the handshake.
+func (s *Sess) CipherSuite() crypto.CipherSuite {
+	return s.suite
+}
+
+// Version returns the wire format version negotiated in the handshake.
+func (s *Sess) Version() uint32 {
+	return s.version
+}
+
+// serve reads the packets of a dialed session until it is closed. The
+// session is closed with the error of its socket if reading fails for good.
+func (s *Sess) serve() {
 	go func() {
 		for {
 			buf, err := s.read()
-			if err != nil {
-				log.Println(err)
+			select {
+			case <-s.quit:
 				return
+			default:
 			}
-			h, err := DecodePacketHeader(buf)
 			if err != nil {
-				log.Println(err)
+				if transientReadError(err) {
+					continue
+				}
+				s.expire(err)
 				return
 			}
-			data, err := s.unmarshalData(buf[h.Size():])
+			_, frame, err := s.decodePacket(buf)
+			if isReplay(err) {
+				continue
+			}
 			if err != nil {
 				log.Println(err)
-				return
+				continue
 			}
-			if h.Type == Pong {
-				pong := decodePongFrame(data)
-				fmt.Println(pong)
+			if err := s.handleFrame(frame); err != nil {
+				log.Println(err)
 			}
 		}
 	}()
 }
 
-func (s *Sess) setConnectionID(id []byte) {
-	copy(s.ConnectionID[:], id)
+// handleFrame processes a frame received on an established session.
+func (s *Sess) handleFrame(frame Frame) error {
+	switch f := frame.(type) {
+	case *DataFrame:
+		return s.receiveData(f)
+	case *DataAckFrame:
+	//s.acknowledge <- f.Bytes()
+	case *PingFrame:
+		return s.Pong(f.StreamID)
+	case *PongFrame:
+		// openPacket already reset the keepalive state.
+	case *ShutdownFrame:
+		return s.shutdown(f)
+	case *ShutAckFrame:
+		select {
+		case s.shutAck <- struct{}{}:
+		default:
+		}
+	case *PathChallengeFrame:
+		return s.writeFrame(&PathResponseFrame{StreamID: f.StreamID, Data: f.Data})
+	case *NewConnectionIDFrame:
+		if s.dialer {
+			return s.addConnectionID(f)
+		}
+	case *RetireConnectionIDFrame:
+		if !s.dialer && s.server != nil {
+			return s.retireConnectionID(f)
+		}
+	}
+	return nil
+}
+
+func (s *Sess) receiveData(frame *DataFrame) error {
+	data, err := frame.RawData()
+	if err != nil {
+		return err
+	}
+	if frame.Length == len(data) {
+		s.queue(data)
+		return nil
+	}
+	if _, ok := s.data[frame.StreamID]; !ok {
+		s.data[frame.StreamID] = buffer.NewBuffer(frame.Length)
+	}
+	buf, ok := s.data[frame.StreamID]
+	if !ok {
+		return nil
+	}
+	buf.WriteBytes(data, frame.Offset)
+	// The last chunk may arrive before the others, only the hash tells
+	// that the message is complete.
+	if frame.Length == buf.Size() && sha256.Sum256(buf.Bytes()) == frame.Hash {
+		delete(s.data, frame.StreamID)
+		s.queue(buf.Bytes())
+	}
+	return nil
+}
+
+// queue hands a message to Receive. It is dropped if the session is closed
+// or its queue is full, so that a session which is not read from does not
+// stall the Conn reading the packets of all the sessions.
+func (s *Sess) queue(msg []byte) {
+	select {
+	case <-s.quit:
+		return
+	default:
+	}
+	select {
+	case s.incoming <- msg:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+func (s *Sess) remoteAddr() net.Addr {
+	s.addrMu.Lock()
+	defer s.addrMu.Unlock()
+	return s.addr
 }
 
-func (s *Sess) encryptData(buf []byte) ([]byte, error) {
-	return crypto.Encrypt(s.secretKey, buf)
+// setRemoteAddr records the address packets are sent to.
+func (s *Sess) setRemoteAddr(addr net.Addr) {
+	s.addrMu.Lock()
+	defer s.addrMu.Unlock()
+	s.addr = addr
 }
 
-func (s *Sess) decryptData(buf []byte) ([]byte, error) {
-	return crypto.Decrypt(s.secretKey, buf)
+func (s *Sess) encryptData(h *PacketHeader, key, buf []byte) ([]byte, error) {
+	return s.suite.Encrypt(key, buf, h.Bytes())
+}
+
+func (s *Sess) decryptData(h *PacketHeader, buf []byte) ([]byte, error) {
+	return s.recvDecrypt(h, buf)
 }
 
 func (s *Sess) compressData(buf []byte) ([]byte, error) {
-	return zstd.CompressLevel(nil, buf, 9)
+	return zstd.CompressLevel(nil, buf, s.compressionLevel)
 }
 
 func (s *Sess) decompressData(buf []byte) ([]byte, error) {
 	return zstd.Decompress(nil, buf)
 }
 
-func (s *Sess) marshalData(buf []byte) ([]byte, error) {
+func (s *Sess) marshalData(h *PacketHeader, key, buf []byte) ([]byte, error) {
 	buf, err := s.compressData(buf)
 	if err != nil {
 		return nil, err
 	}
-	return s.encryptData(buf)
+	return s.encryptData(h, key, buf)
 }
 
-func (s *Sess) unmarshalData(buf []byte) ([]byte, error) {
-	buf, err := s.decryptData(buf)
+func (s *Sess) unmarshalData(h *PacketHeader, buf []byte) ([]byte, error) {
+	buf, err := s.decryptData(h, buf)
 	if err != nil {
 		return nil, err
 	}
 	return s.decompressData(buf)
 }
 
-func (s *Sess) read() ([]byte, error) {
-	buf := make([]byte, bufferSize)
-	n, err := s.conn.Read(buf)
+// openPacket authenticates and decodes the payload of an encrypted packet.
+// Packets whose sequence has already been seen, or is too old to tell, are
+// rejected before decryption and counted in Stats.
+func (s *Sess) openPacket(h *PacketHeader, buf []byte) ([]byte, error) {
+	if err := s.replay.check(h.Sequence); err != nil {
+		s.countReplay(err)
+		return nil, err
+	}
+	data, err := s.unmarshalData(h, buf)
 	if err != nil {
-		e, ok := err.(*net.OpError)
-		fmt.Println(ok, e.Err)
 		return nil, err
 	}
-	return buf[:n], nil
+	if err := s.replay.update(h.Sequence); err != nil {
+		s.countReplay(err)
+		return nil, err
+	}
+	s.touch()
+	return data, nil
+}
+
+func (s *Sess) countReplay(err error) {
+	switch err {
+	case errReplayed:
+		atomic.AddUint64(&s.replayed, 1)
+	case errTooOld:
+		atomic.AddUint64(&s.tooOld, 1)
+	}
+}
+
+// Stats returns a snapshot of the session counters.
+func (s *Sess) Stats() Stats {
+	stats := Stats{
+		Replayed:   atomic.LoadUint64(&s.replayed),
+		TooOld:     atomic.LoadUint64(&s.tooOld),
+		Migrations: atomic.LoadUint64(&s.migrations),
+		Dropped:    atomic.LoadUint64(&s.dropped),
+	}
+	s.keyMu.Lock()
+	stats.KeyUpdates = s.keyUpdates
+	stats.KeyUpdatePending = s.keyUpdatePending()
+	s.keyMu.Unlock()
+	return stats
+}
+
+// decodePacket authenticates and decodes a packet read by a dialed session.
+// Handshake packets sent in clear are decoded as is, any other packet is
+// authenticated and decrypted with the session keys. The frame is nil if its
+// type is unknown or it is too short.
+func (s *Sess) decodePacket(buf []byte) (*PacketHeader, Frame, error) {
+	h, err := DecodePacketHeader(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	payload := buf[h.Size():]
+	if !h.Protected() {
+		switch h.Type {
+		case Init, InitAck, Session, SessAck, Resume, ResumeAck, VersionNegotiation:
+			return h, DecodeFrame(h.Type, payload), nil
+		}
+	}
+	if s.hp == nil {
+		return nil, nil, errors.New("xudp: encrypted packet before handshake completion")
+	}
+	if h.Protected() {
+		if err := unprotectHeader(s.hp, h, buf); err != nil {
+			return nil, nil, err
+		}
+	}
+	data, err := s.openPacket(h, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, DecodeFrame(h.Type, data), nil
+}
+
+func (s *Sess) read() ([]byte, error) {
+	if s.inbox != nil {
+		select {
+		case buf := <-s.inbox:
+			return buf, nil
+		case <-s.inboxDeadline.wait():
+			return nil, ErrDeadlineExceeded
+		case <-s.quit:
+			return nil, s.closeError()
+		}
+	}
+	buf := make([]byte, s.readBufferSize)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return nil, err
+		}
+		// An unconnected socket also receives the packets of other peers.
+		if s.connected || addr.String() == s.remoteAddr().String() {
+			return buf[:n], nil
+		}
+	}
+}
+
+// transientReadError reports whether a socket can still be read after err:
+// timeouts, and the ICMP errors a connected socket reports while the peer is
+// unreachable, which the idle timeout and keepalive deal with.
+func transientReadError(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// deliver queues a packet read by the Conn of a dialed session, dropping it
+// if it does not come from the peer or the queue is full.
+func (s *Sess) deliver(addr net.Addr, packet []byte) {
+	if addr.String() != s.remoteAddr().String() {
+		return
+	}
+	buf := make([]byte, len(packet))
+	copy(buf, packet)
+	select {
+	case s.inbox <- buf:
+	default:
+	}
 }
 
 func (s *Sess) send(buf []byte) error {
-	if s.dialer {
-		_, err := s.conn.Write(buf)
+	atomic.StoreInt64(&s.lastSent, time.Now().UnixNano())
+	if s.connected {
+		_, err := s.conn.(net.Conn).Write(buf)
 		return err
 	}
-	_, err := s.conn.WriteTo(buf, s.addr)
+	_, err := s.conn.WriteTo(buf, s.remoteAddr())
 	return err
 }
 
@@ -140,26 +542,41 @@ func (s *Sess) TempData(frame *DataFrame) {
 	}
 }
 
-func (s *Sess) Close() error {
-	if s.ticker != nil {
-		s.ticker.Stop()
-	}
-	return nil
-}
-
+// NextSequence returns the sequence number of the next packet. It is safe
+// to call from several goroutines.
 func (s *Sess) NextSequence() uint32 {
-	s.Sequence++
-	return s.Sequence
+	return atomic.AddUint32(&s.Sequence, 1)
 }
 
 func (s *Sess) RemoteAddr() string {
-	return s.addr.String()
+	return s.remoteAddr().String()
 }
 
+// Receive returns the next message sent by the peer. Once the session is
+// closed and the messages received before are consumed, it returns
+// ErrSessionClosed, or the ApplicationError or TransportError the peer closed
+// it with.
 func (s *Sess) Receive() ([]byte, error) {
+	return s.ReceiveContext(context.Background())
+}
+
+// ReceiveContext is like Receive but gives up once ctx is done or the read
+// deadline passed.
+func (s *Sess) ReceiveContext(ctx context.Context) ([]byte, error) {
 	select {
 	case in := <-s.incoming:
 		return in, nil
+	default:
+	}
+	select {
+	case in := <-s.incoming:
+		return in, nil
+	case <-s.quit:
+		return nil, s.closeError()
+	case <-ctx.Done():
+		return nil, contextError(ctx)
+	case <-s.readDeadline.wait():
+		return nil, ErrDeadlineExceeded
 	}
 }
 
@@ -168,44 +585,90 @@ func (s *Sess) Ping() error {
 	frame := &PingFrame{
 		StreamID: streamID,
 	}
-	data, err := s.marshalData(frame.Bytes())
-	if err != nil {
-		return err
-	}
-	header := &PacketHeader{
-		Type:         Ping,
-		ConnectionID: s.ConnectionID,
-		Sequence:     s.NextSequence(),
-		Channel:      1,
-	}
-	return s.send(Payload(header, data))
+	return s.writeFrame(frame)
 }
 
 func (s *Sess) Pong(streamID uint32) error {
 	frame := &PongFrame{
 		StreamID: streamID,
 	}
-	data, err := s.marshalData(frame.Bytes())
+	return s.writeFrame(frame)
+}
+
+// writeFrame encrypts frame with the packet header as additional data and
+// sends it to the peer.
+func (s *Sess) writeFrame(frame Frame) error {
+	select {
+	case <-s.quit:
+		return s.closeError()
+	default:
+	}
+	buf, err := s.encodeFrame(frame)
 	if err != nil {
 		return err
 	}
+	return s.send(buf)
+}
+
+func (s *Sess) encodeFrame(frame Frame) ([]byte, error) {
+	raw := frame.Bytes()
 	header := &PacketHeader{
-		Type:         Pong,
-		ConnectionID: s.ConnectionID,
+		Type:         frame.Type(),
+		ConnectionID: s.sendConnectionID(),
 		Sequence:     s.NextSequence(),
-		Channel:      1,
+		Channel:      s.channel,
+	}
+	phase, key := s.sendKey(len(raw))
+	header.KeyPhase = phase
+	data, err := s.marshalData(header, key, raw)
+	if err != nil {
+		return nil, err
 	}
-	return s.send(Payload(header, data))
+	buf := Payload(header, data)
+	if s.protect {
+		if err := protectHeader(s.hp, buf); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
 }
 
 func (s *Sess) Send(buf []byte) error {
-	const chunkSize = 1024
+	return s.SendContext(context.Background(), buf)
+}
+
+// SendContext is like Send but gives up once ctx is done or the write
+// deadline passed, in which case the message may have been partially sent.
+func (s *Sess) SendContext(ctx context.Context, buf []byte) error {
+	return s.sendMessage(ctx, buf, s.writeDeadline.wait())
+}
+
+// sendMessage sends buf in as many Data frames as needed until ctx is done
+// or deadline is closed. A nil deadline never passes.
+func (s *Sess) sendMessage(ctx context.Context, buf []byte, deadline <-chan struct{}) error {
+	s.mu.Lock()
+	if s.closing {
+		err := s.closeErr
+		s.mu.Unlock()
+		return err
+	}
+	s.sending.Add(1)
+	s.mu.Unlock()
+	defer s.sending.Done()
+
 	eg := errgroup.Group{}
 	eg.Go(func() error {
 		streamID := rand.Uint32()
 		hash := sha256.Sum256(buf)
 		length := len(buf)
-		err := buffer.Iterator(buf, chunkSize, func(offset int, chunk []byte) error {
+		err := buffer.Iterator(buf, s.chunkSize, func(offset int, chunk []byte) error {
+			select {
+			case <-ctx.Done():
+				return contextError(ctx)
+			case <-deadline:
+				return ErrDeadlineExceeded
+			default:
+			}
 			f := &DataFrame{
 				StreamID: streamID,
 				Length:   length,
@@ -213,17 +676,7 @@ func (s *Sess) Send(buf []byte) error {
 				Hash:     hash,
 			}
 			f.SetData(chunk)
-			data, err := s.marshalData(f.Bytes())
-			if err != nil {
-				return err
-			}
-			header := &PacketHeader{
-				Type:         Data,
-				ConnectionID: s.ConnectionID,
-				Sequence:     s.NextSequence(),
-				Channel:      1,
-			}
-			return s.send(Payload(header, data))
+			return s.writeFrame(f)
 		})
 		return err
 	})
@@ -1,7 +1,14 @@
 package xudp
 
 import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestNewSess(t *testing.T) {
@@ -11,3 +18,44 @@ func TestNewSess(t *testing.T) {
 	buf := make([]byte, 1024)
 	sess.Send(buf)
 }
+
+func TestSess_QueueFull(t *testing.T) {
+	sess := newSess(nil, nil, &Config{QueueSize: 1})
+	for i := 0; i < 3; i++ {
+		f := &DataFrame{StreamID: uint32(i), Length: 1}
+		f.SetData([]byte{byte(i)})
+		assert.NoError(t, sess.receiveData(f))
+	}
+	assert.Equal(t, uint64(2), sess.Stats().Dropped)
+	buf, err := sess.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0}, buf)
+}
+
+func TestSess_SocketClosed(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	client, err := NewClient(pc, ln.Addr(), nil)
+	assert.NoError(t, err)
+
+	// The session ends with the socket it reads.
+	assert.NoError(t, pc.Close())
+	select {
+	case <-client.quit:
+	case <-time.After(time.Second):
+		t.Fatal("session not closed")
+	}
+	_, err = client.Receive()
+	assert.Error(t, err)
+	assert.Error(t, client.Send([]byte("ping")))
+}
+
+func TestTransientReadError(t *testing.T) {
+	refused := &net.OpError{Op: "read", Net: "udp", Err: os.NewSyscallError("recvfrom", syscall.ECONNREFUSED)}
+	assert.True(t, transientReadError(refused))
+	assert.True(t, transientReadError(ErrDeadlineExceeded))
+	assert.False(t, transientReadError(errors.New("use of closed network connection")))
+}
@@ -0,0 +1,58 @@
+package xudp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	cryptorand "crypto/rand"
+	"encoding/binary"
+)
+
+// ShardConnectionIDGenerator generates connection IDs carrying the
+// identifier of a server, or shard, so that a stateless load balancer can
+// route the packets of a session to the server which accepted it.
+//
+// The first 4 bytes of an ID hold the shard and the others are random. With
+// a key, the whole ID is encrypted with AES so that observers cannot tell
+// the servers apart, and the load balancer needs the same key to read the
+// shard.
+type ShardConnectionIDGenerator struct {
+	shard uint32
+	block cipher.Block // nil if the IDs are not encrypted
+}
+
+// NewShardConnectionIDGenerator returns a generator of connection IDs
+// carrying shard. key is nil, or an AES key of 16, 24 or 32 bytes shared
+// by the servers and the load balancer.
+func NewShardConnectionIDGenerator(shard uint32, key []byte) (*ShardConnectionIDGenerator, error) {
+	g := &ShardConnectionIDGenerator{shard: shard}
+	if key != nil {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		g.block = block
+	}
+	return g, nil
+}
+
+// GenerateConnectionID returns a new connection ID carrying the shard of g.
+func (g *ShardConnectionIDGenerator) GenerateConnectionID() (ConnectionID, error) {
+	var id ConnectionID
+	binary.BigEndian.PutUint32(id[0:4], g.shard)
+	if _, err := cryptorand.Read(id[4:]); err != nil {
+		return ConnectionID{}, err
+	}
+	if g.block != nil {
+		g.block.Encrypt(id[:], id[:])
+	}
+	return id, nil
+}
+
+// Shard returns the shard carried by a connection ID generated with the key
+// of g. Load balancers use it to route packets.
+func (g *ShardConnectionIDGenerator) Shard(id ConnectionID) uint32 {
+	if g.block != nil {
+		g.block.Decrypt(id[:], id[:])
+	}
+	return binary.BigEndian.Uint32(id[0:4])
+}
@@ -0,0 +1,24 @@
+package xudp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardConnectionIDGenerator(t *testing.T) {
+	for _, key := range [][]byte{nil, make([]byte, 16)} {
+		g, err := NewShardConnectionIDGenerator(7, key)
+		assert.NoError(t, err)
+		id, err := g.GenerateConnectionID()
+		assert.NoError(t, err)
+		other, err := g.GenerateConnectionID()
+		assert.NoError(t, err)
+		assert.NotEqual(t, id, other)
+		assert.Equal(t, uint32(7), g.Shard(id))
+		assert.Equal(t, uint32(7), g.Shard(other))
+	}
+
+	_, err := NewShardConnectionIDGenerator(7, make([]byte, 10))
+	assert.Error(t, err)
+}
@@ -0,0 +1,359 @@
+package xudp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/socketfunc/xudp/crypto"
+)
+
+const (
+	ticketContext  = "xudp session ticket"
+	resumeContext  = "xudp resume transcript"
+	ticketLifetime = 24 * time.Hour
+	maxEarlyData   = 1024
+)
+
+var (
+	errResumeRejected = errors.New("xudp: session ticket rejected")
+	errTicket         = errors.New("xudp: invalid session ticket")
+)
+
+// SessionTicket lets a client resume a session with the server that issued
+// it in a single round trip, without a new key exchange nor the client
+// authenticating again, although the server checks that it still accepts
+// the identity the client authenticated with. It is obtained from
+// Sess.SessionTicket and passed back in Config.SessionTicket.
+type SessionTicket struct {
+	ticket           []byte
+	secret           []byte
+	suite            crypto.CipherSuite
+	version          uint32
+	expiry           time.Time
+	peerCertificates []*x509.Certificate
+}
+
+// Expiry returns the time after which the server will not accept the ticket.
+func (t *SessionTicket) Expiry() time.Time {
+	return t.expiry
+}
+
+func (t *SessionTicket) expired() bool {
+	return !time.Now().Before(t.expiry)
+}
+
+// ticketState is what a server seals in a ticket to restore a session
+// without keeping any state.
+type ticketState struct {
+	suite       crypto.CipherSuite
+	secret      []byte
+	expiry      time.Time
+	clientKey   [32]byte
+	credential  []byte
+	pskIdentity []byte
+}
+
+func (t *ticketState) Bytes() []byte {
+	buf := make([]byte, 77+len(t.credential)+len(t.pskIdentity))
+	binary.BigEndian.PutUint16(buf[0:2], uint16(t.suite))
+	binary.BigEndian.PutUint64(buf[2:10], uint64(t.expiry.Unix()))
+	copy(buf[10:42], t.secret)
+	copy(buf[42:74], t.clientKey[:])
+	binary.BigEndian.PutUint16(buf[74:76], uint16(len(t.credential)))
+	copy(buf[76:], t.credential)
+	psk := 76 + len(t.credential)
+	buf[psk] = uint8(len(t.pskIdentity))
+	copy(buf[psk+1:], t.pskIdentity)
+	return buf
+}
+
+func decodeTicketState(buf []byte) (*ticketState, error) {
+	if len(buf) < 77 {
+		return nil, errTicket
+	}
+	t := &ticketState{}
+	t.suite = crypto.CipherSuite(binary.BigEndian.Uint16(buf[0:2]))
+	t.expiry = time.Unix(int64(binary.BigEndian.Uint64(buf[2:10])), 0)
+	t.secret = append([]byte(nil), buf[10:42]...)
+	copy(t.clientKey[:], buf[42:74])
+	n := int(binary.BigEndian.Uint16(buf[74:76]))
+	if len(buf) < 77+n {
+		return nil, errTicket
+	}
+	if n > 0 {
+		t.credential = append([]byte(nil), buf[76:76+n]...)
+	}
+	psk := 76 + n
+	n = int(buf[psk])
+	if len(buf) != psk+1+n {
+		return nil, errTicket
+	}
+	if n > 0 {
+		t.pskIdentity = append([]byte(nil), buf[psk+1:]...)
+	}
+	return t, nil
+}
+
+func (t *ticketState) identity() *ClientIdentity {
+	if t.clientKey == [32]byte{} && t.credential == nil {
+		return nil
+	}
+	id := &ClientIdentity{
+		Credential: t.credential,
+	}
+	if t.clientKey != [32]byte{} {
+		id.Key = append([]byte(nil), t.clientKey[:]...)
+	}
+	return id
+}
+
+// resumptionSecret is the secret shared by both sides of a session which a
+// ticket resumes from. It is never used to protect packets directly.
+func resumptionSecret(secret []byte) []byte {
+	return crypto.DeriveKey(secret, "xudp resumption", 32)
+}
+
+func resumedSecret(resumption []byte, clientNonce, serverNonce [32]byte) []byte {
+	ikm := make([]byte, 0, len(resumption)+64)
+	ikm = append(ikm, resumption...)
+	ikm = append(ikm, clientNonce[:]...)
+	ikm = append(ikm, serverNonce[:]...)
+	return crypto.DeriveKey(ikm, "xudp resumed secret", 32)
+}
+
+func earlyDataKey(resumption []byte, clientNonce [32]byte, suite crypto.CipherSuite) []byte {
+	ikm := make([]byte, 0, len(resumption)+32)
+	ikm = append(ikm, resumption...)
+	ikm = append(ikm, clientNonce[:]...)
+	return crypto.DeriveKey(ikm, "xudp early data", suite.KeySize())
+}
+
+func resumeTranscript(id ConnectionID, resume *ResumeFrame, resumeAck *ResumeAckFrame) []byte {
+	h := sha256.New()
+	h.Write([]byte(resumeContext))
+	h.Write(id[:])
+	h.Write(resume.Bytes())
+	h.Write(resumeAck.signed())
+	return h.Sum(nil)
+}
+
+// newTicketFrame seals the state needed to resume sess with the ticket key
+// of the Conn.
+func (c *Conn) newTicketFrame(sess *Sess) (*TicketFrame, error) {
+	state := &ticketState{
+		suite:       sess.suite,
+		secret:      resumptionSecret(sess.secretKey),
+		expiry:      time.Now().Add(ticketLifetime),
+		pskIdentity: sess.pskIdentity,
+	}
+	if sess.identity != nil {
+		copy(state.clientKey[:], sess.identity.Key)
+		state.credential = sess.identity.Credential
+	}
+	ticket, err := crypto.Encrypt(c.ticketKey, state.Bytes(), []byte(ticketContext))
+	if err != nil {
+		return nil, err
+	}
+	return &TicketFrame{
+		StreamID: rand.Uint32(),
+		Lifetime: uint32(ticketLifetime / time.Second),
+		Ticket:   ticket,
+	}, nil
+}
+
+func (c *Conn) openTicket(ticket []byte) (*ticketState, error) {
+	buf, err := crypto.Decrypt(c.ticketKey, ticket, []byte(ticketContext))
+	if err != nil {
+		return nil, errTicket
+	}
+	state, err := decodeTicketState(buf)
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(state.expiry) {
+		return nil, fmt.Errorf("%w: expired", errTicket)
+	}
+	if !state.suite.Supported() {
+		return nil, errTicket
+	}
+	return state, nil
+}
+
+// sendTicket issues a new ticket for the session of hs unless tickets are
+// disabled.
+func (c *Conn) sendTicket(hs *serverHandshake) error {
+	if c.config.SessionTicketsDisabled {
+		return nil
+	}
+	frame, err := c.newTicketFrame(hs.sess)
+	if err != nil {
+		return err
+	}
+	return c.respondFrame(hs, frame)
+}
+
+// resumeHandler restores the session sealed in the ticket of frame, or
+// tells the client to fall back to a full handshake.
+func (c *Conn) resumeHandler(h *PacketHeader, frame *ResumeFrame, addr net.Addr) error {
+	state, err := c.openTicket(frame.Ticket)
+	if err == nil && !hasVersion(c.config.versions(), frame.Version) {
+		err = fmt.Errorf("%w: client resumed version %d", ErrVersionNegotiation, frame.Version)
+	}
+	s := c.newSess(addr)
+	s.setConnectionID(h.ConnectionID[:])
+	if err == nil {
+		s.identity = state.identity()
+		s.pskIdentity = state.pskIdentity
+		err = c.revalidate(s)
+	}
+	if err != nil {
+		// As for Init, nothing is kept for a rejected frame, its
+		// retransmissions are rejected again.
+		ack := &ResumeAckFrame{
+			StreamID: frame.StreamID,
+			Status:   ResumeRejected,
+		}
+		packet := NewPacket(h.ConnectionID[:], h.Sequence+1, h.Channel, ack)
+		if _, werr := c.conn.WriteTo(packet.Bytes(), addr); werr != nil {
+			return werr
+		}
+		return err
+	}
+	var early []byte
+	if len(frame.EarlyData) > 0 {
+		key := earlyDataKey(state.secret, frame.Nonce, state.suite)
+		early, err = state.suite.Decrypt(key, frame.EarlyData, h.ConnectionID[:])
+		if err != nil {
+			return fmt.Errorf("xudp: decrypt early data error. %w", err)
+		}
+	}
+	f := &ResumeAckFrame{
+		StreamID:    rand.Uint32(),
+		Status:      ResumeAccepted,
+		Nonce:       newNonce(),
+		IdleTimeout: idleTimeoutMillis(c.config.idleTimeout()),
+	}
+	if !c.config.SessionTicketsDisabled {
+		f.Flags |= flagTicket
+	}
+	secret := resumedSecret(state.secret, frame.Nonce, f.Nonce)
+	f.Binder = pskBinder(secret, resumeTranscript(h.ConnectionID, frame, f))
+	s.setSecret(secret, state.suite)
+	s.Sequence = h.Sequence
+	s.version = frame.Version
+	s.negotiateIdleTimeout(frame.IdleTimeout)
+	s.established()
+	s.resumed = true
+	c.setSess(s.ConnectionID, s)
+	hs := c.startHandshake(s, frame)
+	ack := NewPacket(h.ConnectionID[:], h.Sequence+1, h.Channel, f)
+	if err := c.respond(hs, ack.Bytes()); err != nil {
+		return err
+	}
+	if err := c.sendTicket(hs); err != nil {
+		return err
+	}
+	if early != nil {
+		s.queue(early)
+	}
+	return c.accept(hs)
+}
+
+// revalidate checks that the client identity and the pre-shared key
+// identity restored from a ticket are still accepted, so that a client
+// rejected or revoked since the ticket was issued falls back to a full
+// handshake.
+func (c *Conn) revalidate(sess *Sess) error {
+	if sess.pskIdentity != nil {
+		if c.config.GetPSK == nil {
+			return errors.New("xudp: pre-shared keys are not supported")
+		}
+		if _, err := c.config.GetPSK(sess.pskIdentity); err != nil {
+			return fmt.Errorf("xudp: unknown psk identity. %w", err)
+		}
+	}
+	if c.config.Authenticator != nil {
+		if sess.identity == nil {
+			return fmt.Errorf("%w: ticket carries no identity", ErrClientAuthentication)
+		}
+		if err := c.config.Authenticator(sess, sess.identity); err != nil {
+			return fmt.Errorf("%w: %v", ErrClientAuthentication, err)
+		}
+	}
+	return nil
+}
+
+// resume opens sess from ticket, sending early in the first flight. It
+// returns errResumeRejected if the server wants a full handshake instead.
+func resume(sess *Sess, ticket *SessionTicket, early []byte) error {
+	frame := &ResumeFrame{
+		StreamID:    rand.Uint32(),
+		Nonce:       newNonce(),
+		Ticket:      ticket.ticket,
+		Version:     ticket.version,
+		IdleTimeout: idleTimeoutMillis(sess.idleTimeout),
+	}
+	if len(early) > 0 {
+		key := earlyDataKey(ticket.secret, frame.Nonce, ticket.suite)
+		data, err := ticket.suite.Encrypt(key, early, sess.ConnectionID[:])
+		if err != nil {
+			return err
+		}
+		frame.EarlyData = data
+	}
+	err := sess.sendFlight(func() error {
+		packet := NewPacket(sess.ConnectionID[:], sess.NextSequence(), sess.channel, frame)
+		return sess.send(packet.Bytes())
+	})
+	if err != nil {
+		return err
+	}
+	f, err := sess.awaitFrame(StageResume, ResumeAck)
+	if err != nil {
+		return err
+	}
+	ack := f.(*ResumeAckFrame)
+	if ack.Status != ResumeAccepted {
+		return errResumeRejected
+	}
+	secret := resumedSecret(ticket.secret, frame.Nonce, ack.Nonce)
+	binder := pskBinder(secret, resumeTranscript(sess.ConnectionID, frame, ack))
+	if !hmac.Equal(binder[:], ack.Binder[:]) {
+		return fmt.Errorf("%w: invalid resumption binder", ErrServerAuthentication)
+	}
+	sess.setSecret(secret, ticket.suite)
+	sess.version = ticket.version
+	sess.negotiateIdleTimeout(ack.IdleTimeout)
+	sess.peerCertificates = ticket.peerCertificates
+	sess.resumed = true
+	if ack.Flags&flagTicket != 0 {
+		return receiveTicket(sess)
+	}
+	return nil
+}
+
+// receiveTicket reads the ticket the server issues at the end of the
+// handshake and keeps it for Sess.SessionTicket.
+func receiveTicket(sess *Sess) error {
+	frame, err := sess.awaitFrame(StageTicket, Ticket)
+	if err != nil {
+		return err
+	}
+	f := frame.(*TicketFrame)
+	sess.ticket = &SessionTicket{
+		ticket:           f.Ticket,
+		secret:           resumptionSecret(sess.secretKey),
+		suite:            sess.suite,
+		version:          sess.version,
+		expiry:           time.Now().Add(time.Duration(f.Lifetime) * time.Second),
+		peerCertificates: sess.peerCertificates,
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package xudp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/socketfunc/xudp/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTicket(t *testing.T) {
+	c := &Conn{config: &Config{}, ticketKey: make([]byte, 32)}
+	sess := NewSess(nil, nil, nil)
+	sess.setSecret(make([]byte, 32), crypto.ChaCha20Poly1305)
+	sess.identity = &ClientIdentity{Credential: []byte("token")}
+	sess.pskIdentity = []byte("device")
+
+	frame, err := c.newTicketFrame(sess)
+	assert.NoError(t, err)
+	frame = decodeTicketFrame(frame.Bytes())
+
+	state, err := c.openTicket(frame.Ticket)
+	assert.NoError(t, err)
+	assert.Equal(t, crypto.ChaCha20Poly1305, state.suite)
+	assert.Equal(t, resumptionSecret(sess.secretKey), state.secret)
+	assert.Equal(t, sess.identity, state.identity())
+	assert.Equal(t, []byte("device"), state.pskIdentity)
+
+	other := &Conn{config: &Config{}, ticketKey: make([]byte, 32)}
+	other.ticketKey[0] = 1
+	_, err = other.openTicket(frame.Ticket)
+	assert.True(t, errors.Is(err, errTicket))
+
+	state.expiry = time.Now().Add(-time.Second)
+	sealed, err := crypto.Encrypt(c.ticketKey, state.Bytes(), []byte(ticketContext))
+	assert.NoError(t, err)
+	_, err = c.openTicket(sealed)
+	assert.True(t, errors.Is(err, errTicket))
+}
+
+func TestDialEarly(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	client, err := Dial("udp", ln.Addr().String())
+	assert.NoError(t, err)
+	_, err = ln.Accept()
+	assert.NoError(t, err)
+	ticket := client.SessionTicket()
+	assert.NotNil(t, ticket)
+	assert.NoError(t, client.Close())
+
+	config := &Config{SessionTicket: ticket}
+	client, err = DialEarly("udp", ln.Addr().String(), config, []byte("early"))
+	assert.NoError(t, err)
+	defer client.Close()
+	server, err := ln.Accept()
+	assert.NoError(t, err)
+	assert.True(t, client.Resumed())
+	assert.True(t, server.Resumed())
+	buf, err := server.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("early"), buf)
+	assertExchange(t, client, server)
+
+	// Another server rejects the ticket, the client falls back to a full
+	// handshake and sends the early data afterwards.
+	other, err := Listen("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer other.Close()
+	client, err = DialEarly("udp", other.Addr().String(), config, []byte("early"))
+	assert.NoError(t, err)
+	defer client.Close()
+	server, err = other.Accept()
+	assert.NoError(t, err)
+	assert.False(t, client.Resumed())
+	assert.False(t, server.Resumed())
+	buf, err = server.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("early"), buf)
+}
+
+func TestDialEarly_Revoked(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	var revoked int32
+	ln, err := ListenWithConfig("127.0.0.1:0", &Config{
+		Authenticator: func(sess *Sess, id *ClientIdentity) error {
+			if atomic.LoadInt32(&revoked) != 0 {
+				return errors.New("revoked")
+			}
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	config := &Config{ClientKey: private}
+	client, err := DialWithConfig("udp", ln.Addr().String(), config)
+	assert.NoError(t, err)
+	_, err = ln.Accept()
+	assert.NoError(t, err)
+	config.SessionTicket = client.SessionTicket()
+	assert.NoError(t, client.Close())
+
+	client, err = DialEarly("udp", ln.Addr().String(), config, []byte("early"))
+	assert.NoError(t, err)
+	assert.True(t, client.Resumed())
+	server, err := ln.Accept()
+	assert.NoError(t, err)
+	assert.Equal(t, private.Public(), server.ClientIdentity().Key)
+	assert.NoError(t, client.Close())
+
+	// The ticket is refused once the client is, and so is the full
+	// handshake the client falls back to.
+	atomic.StoreInt32(&revoked, 1)
+	_, err = DialEarly("udp", ln.Addr().String(), config, []byte("early"))
+	assert.True(t, errors.Is(err, ErrClientRejected))
+}
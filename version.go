@@ -0,0 +1,102 @@
+package xudp
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Version1 is the first version of the xudp wire format.
+const Version1 uint32 = 1
+
+// SupportedVersions are the versions of the wire format implemented by this
+// package, most preferred first.
+var SupportedVersions = []uint32{Version1}
+
+// selectVersion returns the first version of preference which is also in
+// offered.
+func selectVersion(preference, offered []uint32) (uint32, bool) {
+	for _, version := range preference {
+		if hasVersion(offered, version) {
+			return version, true
+		}
+	}
+	return 0, false
+}
+
+// negotiated reports whether version is the one a client offering offered
+// ends up with when talking to a server supporting supported: the first one
+// offered if the server supports it, or the first one offered the server
+// listed in its VersionNegotiation frame. Any other version means that the
+// VersionNegotiation frame was forged to downgrade the session.
+func negotiated(offered, supported []uint32, version uint32) bool {
+	v, ok := selectVersion(offered, supported)
+	return ok && v == version
+}
+
+func hasVersion(versions []uint32, version uint32) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// initiate sends the Init frame of the handshake and returns the InitAck of
+// the server. If the server does not support the preferred version, the
+// Init frame is sent again once with the best version both sides support.
+func initiate(sess *Sess, config *Config) (*InitAckFrame, error) {
+	versions := config.versions()
+	if len(versions) == 0 {
+		return nil, ErrVersionNegotiation
+	}
+	version := versions[0]
+	for retried := false; ; retried = true {
+		init := &InitFrame{
+			StreamID: rand.Uint32(),
+			Version:  version,
+		}
+		err := sess.sendFlight(func() error {
+			packet := NewPacket(sess.ConnectionID[:], sess.NextSequence(), sess.channel, init)
+			return sess.send(packet.Bytes())
+		})
+		if err != nil {
+			return nil, err
+		}
+		frame, err := awaitInitAck(sess, init)
+		if err != nil {
+			return nil, err
+		}
+		switch f := frame.(type) {
+		case *InitAckFrame:
+			sess.version = version
+			return f, nil
+		case *VersionNegotiationFrame:
+			// A server never lists the version it refused, and only one
+			// negotiation is expected.
+			if retried || hasVersion(f.Versions, version) {
+				return nil, fmt.Errorf("%w: invalid version negotiation", ErrVersionNegotiation)
+			}
+			v, ok := selectVersion(versions, f.Versions)
+			if !ok {
+				return nil, fmt.Errorf("%w: server supports %v", ErrVersionNegotiation, f.Versions)
+			}
+			version = v
+		}
+	}
+}
+
+// awaitInitAck returns the InitAck or VersionNegotiation frame answering
+// init. VersionNegotiation frames answering the retransmissions of an
+// earlier Init frame are ignored.
+func awaitInitAck(sess *Sess, init *InitFrame) (Frame, error) {
+	for {
+		frame, err := sess.awaitFrame(StageInit, InitAck, VersionNegotiation)
+		if err != nil {
+			return nil, err
+		}
+		if f, ok := frame.(*VersionNegotiationFrame); !ok || f.StreamID == init.StreamID {
+			return frame, nil
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package xudp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectVersion(t *testing.T) {
+	version, ok := selectVersion([]uint32{3, 2, 1}, []uint32{1, 2})
+	assert.True(t, ok)
+	assert.Equal(t, uint32(2), version)
+
+	_, ok = selectVersion([]uint32{3}, []uint32{1, 2})
+	assert.False(t, ok)
+}
+
+func TestNegotiated(t *testing.T) {
+	assert.True(t, negotiated([]uint32{3, 2, 1}, []uint32{3, 1}, 3))
+	assert.True(t, negotiated([]uint32{3, 2, 1}, []uint32{1, 2}, 2))
+	assert.False(t, negotiated([]uint32{3, 2, 1}, []uint32{1, 2}, 1))
+	assert.False(t, negotiated(nil, []uint32{1}, 1))
+}
+
+func TestConfig_Versions(t *testing.T) {
+	assert.Equal(t, SupportedVersions, (&Config{}).versions())
+	assert.Equal(t, []uint32{Version1}, (&Config{Versions: []uint32{100, Version1}}).versions())
+}
+
+func TestVersionNegotiationFrame(t *testing.T) {
+	frame := &VersionNegotiationFrame{
+		StreamID: 1,
+		Versions: []uint32{2, 1},
+	}
+	decoded := DecodeFrame(VersionNegotiation, frame.Bytes())
+	assert.Equal(t, frame, decoded)
+}
@@ -1,6 +1,9 @@
 package xudp
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"log"
@@ -9,8 +12,6 @@ import (
 	"sync"
 	"time"
 
-	uuid "github.com/satori/go.uuid"
-	"github.com/socketfunc/xudp/buffer"
 	"github.com/socketfunc/xudp/crypto"
 )
 
@@ -19,16 +20,26 @@ func init() {
 }
 
 const (
-	bufferSize = 4096
-	queueSize  = 128
+	bufferSize              = 4096
+	queueSize               = 128
+	maxChunkSize            = 1024
+	defaultCompressionLevel = 9
+	defaultChannel          = 1
+	defaultHandshakeTimeout = 10 * time.Second
+	defaultCloseTimeout     = 500 * time.Millisecond
 )
 
 type Conn struct {
-	conn      *net.UDPConn
+	config    *Config
+	ticketKey []byte
+	conn      net.PacketConn
 	sessions  sync.Map
-	bytePool  sync.Pool
-	accepting chan *Sess
-	quit      chan struct{}
+	// handshakes holds the *serverHandshake of recent connection IDs.
+	handshakes sync.Map
+	bytePool   sync.Pool
+	accepting  chan *Sess
+	quit       chan struct{}
+	closeOnce  sync.Once
 }
 
 func (c *Conn) listen() {
@@ -51,59 +62,118 @@ func (c *Conn) loop() error {
 	defer c.putBufferPool(buf)
 	n, addr, err := c.conn.ReadFrom(buf)
 	if err != nil {
-		return err
+		if transientReadError(err) {
+			return err
+		}
+		select {
+		case <-c.quit:
+		default:
+			// The socket cannot be read anymore, which ends the Conn.
+			log.Println(err)
+			c.Close()
+		}
+		return nil
 	}
 	h, err := DecodePacketHeader(buf[:n])
 	if err != nil {
 		return err
 	}
-	if h.Type == None {
+	if !h.Protected() {
+		payload := buf[h.Size():n]
+		switch h.Type {
+		case Init:
+			if frame, ok := DecodeFrame(h.Type, payload).(*InitFrame); ok {
+				return c.initHandler(h, frame, addr)
+			}
+			return nil
+		case Session:
+			frame, ok := DecodeFrame(h.Type, payload).(*SessionFrame)
+			if !ok || c.retransmitted(h.ConnectionID, frame.Bytes(), addr) {
+				return nil
+			}
+			if _, ok := c.getSess(h.ConnectionID); ok {
+				// The connection ID is already in use.
+				return nil
+			}
+			return c.sessionHandler(h, frame, addr)
+		case Resume:
+			frame, ok := DecodeFrame(h.Type, payload).(*ResumeFrame)
+			if !ok || c.retransmitted(h.ConnectionID, frame.Bytes(), addr) {
+				return nil
+			}
+			if _, ok := c.getSess(h.ConnectionID); ok {
+				return nil
+			}
+			return c.resumeHandler(h, frame, addr)
+		}
+	}
+	sess, ok := c.getSess(h.ConnectionID)
+	if !ok {
+		// A rejected client may still retransmit its Auth frame.
+		hs, found := c.getHandshake(h.ConnectionID)
+		if !found || hs.state != handshakeRejected {
+			return nil
+		}
+		sess = hs.sess
+	}
+	if sess.inbox != nil {
+		sess.deliver(addr, buf[:n])
 		return nil
 	}
-	switch h.Type {
-	case Init:
-		return c.initHandler(h, addr)
-	case Session:
-		frame := decodeSessionFrame(buf[h.Size():n])
-		sess, err := c.sessionHandler(h, frame, addr)
-		if err != nil {
+	if h.Protected() {
+		if err := unprotectHeader(sess.hp, h, buf[:n]); err != nil {
 			return err
 		}
-		c.setSess(h.ConnectionID, sess)
-		c.accepting <- sess
+	}
+	if h.Type == None {
 		return nil
 	}
-	sess, ok := c.getSess(h.ConnectionID)
-	if !ok {
+	data, err := sess.openPacket(h, buf[h.Size():n])
+	if isReplay(err) {
 		return nil
 	}
-	data, err := sess.unmarshalData(buf[h.Size():n])
 	if err != nil {
 		return fmt.Errorf("xudp: decrypt data error. %w", err)
 	}
-	sess.addr = addr
-	switch h.Type {
-	case Data:
-		frame := decodeDataFrame(data)
-		return c.dataHandler(sess, frame)
-	case DataAck:
-	//frame := decodeDataAckFrame(data)
-	//sess.acknowledge <- frame.Bytes()
-	case Ping:
-		frame := decodePingFrame(data)
-		fmt.Println(frame.StreamID)
-		return sess.Pong(frame.StreamID)
-	case Pong:
-		frame := decodePongFrame(data)
-		fmt.Println(frame.StreamID)
+	if h.Type == Auth && c.retransmitted(h.ConnectionID, data, addr) {
+		return nil
 	}
-	return nil
+	frame := DecodeFrame(h.Type, data)
+	if sess.pending {
+		if f, ok := frame.(*AuthFrame); ok {
+			return c.authHandler(sess, f)
+		}
+		return nil
+	}
+	sess.useConnectionID(h.ConnectionID)
+	if f, ok := frame.(*PathResponseFrame); ok {
+		sess.validatePath(addr, f)
+		return nil
+	}
+	if err := sess.challengePath(addr); err != nil {
+		return err
+	}
+	return sess.handleFrame(frame)
+}
+
+// newSess creates a session accepted or dialed by the Conn.
+func (c *Conn) newSess(addr net.Addr) *Sess {
+	s := newSess(c.conn, addr, c.config)
+	s.server = c
+	return s
 }
 
 func (c *Conn) setSess(id ConnectionID, sess *Sess) {
 	c.sessions.Store(id, sess)
 }
 
+// claimSess routes the packets of id to sess unless they already go to
+// another session, and reports whether they go to sess.
+func (c *Conn) claimSess(id ConnectionID, sess *Sess) bool {
+	actual, _ := c.sessions.LoadOrStore(id, sess)
+	return actual.(*Sess) == sess
+}
+
 func (c *Conn) getSess(id ConnectionID) (*Sess, bool) {
 	sess, ok := c.sessions.Load(id)
 	if !ok {
@@ -123,69 +193,173 @@ func (c *Conn) putBufferPool(buf []byte) {
 	c.bytePool.Put(buf)
 }
 
-func (c *Conn) initHandler(h *PacketHeader, addr net.Addr) error {
-	f := &InitAckFrame{
-		StreamID: rand.Uint32(),
+// initHandler answers an Init frame with a token, or with the supported
+// versions if the client asked for another one.
+func (c *Conn) initHandler(h *PacketHeader, frame *InitFrame, addr net.Addr) error {
+	// The answer only depends on frame, so a retransmitted Init frame gets
+	// the same answer without keeping any state.
+	var f Frame = &InitAckFrame{
+		StreamID: frame.StreamID,
 		Token:    c.createToken(addr),
 	}
+	if !hasVersion(c.config.versions(), frame.Version) {
+		f = &VersionNegotiationFrame{
+			StreamID: frame.StreamID,
+			Versions: c.config.versions(),
+		}
+	}
 	ack := NewPacket(h.ConnectionID[:], h.Sequence+1, h.Channel, f)
 	_, err := c.conn.WriteTo(ack.Bytes(), addr)
 	return err
 }
 
-func (c *Conn) sessionHandler(h *PacketHeader, frame *SessionFrame, addr net.Addr) (*Sess, error) {
+// sessionHandler creates the session of a Session frame and sends the
+// response of the server, queueing the session for Accept unless the client
+// has to authenticate, or prove it holds its pre-shared key, first.
+func (c *Conn) sessionHandler(h *PacketHeader, frame *SessionFrame, addr net.Addr) error {
 	if !c.verifyToken(frame.Token) {
-		return nil, errors.New("xudp: invalid token")
+		return errors.New("xudp: invalid token")
 	}
-	private, public, err := crypto.GenerateKeys()
-	if err != nil {
-		return nil, err
+	if !hasVersion(c.config.versions(), frame.Version) {
+		return fmt.Errorf("%w: client selected version %d", ErrVersionNegotiation, frame.Version)
 	}
-	pk := crypto.GeneratePublicKey(frame.Key[:])
-	secret, err := crypto.ComputeSecret(private, pk)
-	if err != nil {
-		return nil, err
+	if !negotiated(frame.Versions, c.config.versions(), frame.Version) {
+		return fmt.Errorf("%w: client was downgraded to version %d", ErrVersionNegotiation, frame.Version)
+	}
+	suite, ok := crypto.SelectCipherSuite(c.config.cipherSuites(), frame.CipherSuites)
+	if !ok {
+		return errors.New("xudp: no cipher suite in common")
 	}
 	f := &SessAckFrame{
-		StreamID: rand.Uint32(),
+		StreamID:    rand.Uint32(),
+		CipherSuite: suite,
+		Nonce:       newNonce(),
+		IdleTimeout: idleTimeoutMillis(c.config.idleTimeout()),
+	}
+	var psk []byte
+	if len(frame.PSKIdentity) > 0 {
+		if c.config.GetPSK == nil {
+			return errors.New("xudp: pre-shared keys are not supported")
+		}
+		var err error
+		psk, err = c.config.GetPSK(frame.PSKIdentity)
+		if err != nil {
+			return fmt.Errorf("xudp: unknown psk identity. %w", err)
+		}
+		f.Flags |= flagPSK
+	} else if !frame.hasKey() {
+		return errors.New("xudp: missing key share")
 	}
-	f.setKey(public.Bytes())
-	ack := NewPacket(h.ConnectionID[:], h.Sequence+1, h.Channel, f)
-	if _, err := c.conn.WriteTo(ack.Bytes(), addr); err != nil {
-		return nil, err
+	var secret []byte
+	if frame.hasKey() {
+		private, public, err := crypto.GenerateKeys()
+		if err != nil {
+			return err
+		}
+		pk := crypto.GeneratePublicKey(frame.Key[:])
+		secret, err = crypto.ComputeSecret(private, pk)
+		if err != nil {
+			return err
+		}
+		f.setKey(public.Bytes())
+	}
+	if psk != nil {
+		secret = pskSecret(psk, secret, frame.Nonce, f.Nonce)
+	}
+	if c.config.Authenticator != nil || psk != nil {
+		f.Flags |= flagClientAuth
+	}
+	if c.config.Certificate != nil {
+		f.Flags |= flagCertificate
+	}
+	if !c.config.SessionTicketsDisabled {
+		f.Flags |= flagTicket
+	}
+	if c.config.Identity != nil {
+		signSessAck(c.config.Identity, h.ConnectionID, frame, f)
+	}
+	transcript := handshakeTranscript(h.ConnectionID, frame, f)
+	if psk != nil {
+		f.Binder = pskBinder(secret, transcript)
 	}
-	s := NewSess(c.conn, addr, secret)
-	s.ConnectionID = h.ConnectionID
+	var certificates []*CertificateFrame
+	if c.config.Certificate != nil {
+		var err error
+		certificates, err = certificateFrames(c.config.Certificate, transcript)
+		if err != nil {
+			return err
+		}
+	}
+	s := c.newSess(addr)
+	s.setSecret(secret, suite)
+	s.setConnectionID(h.ConnectionID[:])
 	s.Sequence = h.Sequence
-	return s, nil
-}
-
-func (c *Conn) dataHandler(sess *Sess, frame *DataFrame) error {
-	data, err := frame.RawData()
-	if err != nil {
+	s.version = frame.Version
+	s.pskIdentity = frame.PSKIdentity
+	s.negotiateIdleTimeout(frame.IdleTimeout)
+	s.established()
+	c.setSess(s.ConnectionID, s)
+	hs := c.startHandshake(s, frame)
+	ack := NewPacket(h.ConnectionID[:], h.Sequence+1, h.Channel, f)
+	if err := c.respond(hs, ack.Bytes()); err != nil {
 		return err
 	}
-	if frame.Length == len(data) {
-		sess.incoming <- data
+	for _, certificate := range certificates {
+		if err := c.respondFrame(hs, certificate); err != nil {
+			return err
+		}
+	}
+	if f.Flags&flagClientAuth != 0 {
+		s.transcript = transcript
+		s.pending = true
 		return nil
 	}
-	if _, ok := sess.data[frame.StreamID]; !ok {
-		sess.data[frame.StreamID] = buffer.NewBuffer(frame.Length)
+	if err := c.sendTicket(hs); err != nil {
+		return err
 	}
-	buf, ok := sess.data[frame.StreamID]
-	if !ok {
+	return c.accept(hs)
+}
+
+// authHandler verifies the identity of a client and passes it to the
+// Authenticator. Accepted sessions are queued for Accept, rejected ones are
+// forgotten once the handshake times out.
+//
+// Without an Authenticator, the Auth frame of a pre-shared key session only
+// confirms the key: the frame could only be encrypted by a holder of the
+// key, since the session keys are derived from it.
+func (c *Conn) authHandler(sess *Sess, frame *AuthFrame) error {
+	hs, ok := c.getHandshake(sess.ConnectionID)
+	if !ok || hs.state != handshakeAuthPending {
 		return nil
 	}
-	buf.WriteBytes(data, frame.Offset)
-	if frame.Length == buf.Size() {
-		delete(sess.data, frame.StreamID)
-		sess.incoming <- buf.Bytes()
+	hs.answer(frame.Bytes())
+	var err error
+	if c.config.Authenticator != nil {
+		var id *ClientIdentity
+		id, err = verifyClient(frame, sess.transcript)
+		if err == nil {
+			sess.identity = id
+			if err = c.config.Authenticator(sess, id); err != nil {
+				err = fmt.Errorf("%w: %v", ErrClientAuthentication, err)
+			}
+		}
 	}
-	return nil
+	if err != nil {
+		hs.state = handshakeRejected
+		sess.terminate()
+		_ = c.respondFrame(hs, &AuthAckFrame{StreamID: frame.StreamID, Status: AuthRejected})
+		return err
+	}
+	if err := c.respondFrame(hs, &AuthAckFrame{StreamID: frame.StreamID, Status: AuthAccepted}); err != nil {
+		return err
+	}
+	if err := c.sendTicket(hs); err != nil {
+		return err
+	}
+	return c.accept(hs)
 }
 
 func (c *Conn) createToken(addr net.Addr) [16]byte {
-	fmt.Println(addr)
 	return [16]byte{}
 }
 
@@ -193,115 +367,234 @@ func (c *Conn) verifyToken(token [16]byte) bool {
 	return true
 }
 
+// Close stops listening and releases all the sessions without telling the
+// clients, use Sess.Close first for that.
 func (c *Conn) Close() {
-	close(c.quit)
-	if c.conn != nil {
-		c.conn.Close()
-	}
+	c.closeOnce.Do(func() {
+		close(c.quit)
+		c.sessions.Range(func(_, sess interface{}) bool {
+			sess.(*Sess).terminate()
+			return true
+		})
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	})
+}
+
+// Versions returns the wire format versions the Conn accepts, most
+// preferred first, as configured. The version of a session is returned by
+// Sess.Version.
+func (c *Conn) Versions() []uint32 {
+	return c.config.versions()
 }
 
 func (c *Conn) Accept() (*Sess, error) {
+	return c.AcceptContext(context.Background())
+}
+
+// AcceptContext is like Accept but gives up once ctx is done.
+func (c *Conn) AcceptContext(ctx context.Context) (*Sess, error) {
 	select {
 	case sess := <-c.accepting:
 		return sess, nil
+	case <-c.quit:
+		return nil, ErrConnClosed
+	case <-ctx.Done():
+		return nil, contextError(ctx)
 	}
 }
 
 func Listen(addr string) (*Conn, error) {
-	udpAddr, err := net.ResolveUDPAddr(protocol, addr)
-	if err != nil {
-		return nil, err
-	}
-	conn, err := net.ListenUDP(protocol, udpAddr)
-	if err != nil {
-		return nil, err
-	}
-	c := &Conn{
-		conn:     conn,
-		sessions: sync.Map{},
-		bytePool: sync.Pool{
-			New: func() interface{} {
-				return make([]byte, bufferSize)
-			},
-		},
-		accepting: make(chan *Sess, queueSize),
-		quit:      make(chan struct{}),
-	}
-	c.listen()
-	return c, nil
+	return ListenWithConfig(addr, nil)
+}
+
+// ListenWithConfig announces on the local UDP address addr and accepts
+// sessions configured by config.
+func ListenWithConfig(addr string, config *Config) (*Conn, error) {
+	lc := &ListenConfig{Config: config}
+	return lc.Listen(context.Background(), protocol, addr)
 }
 
 func Dial(network, addr string) (*Sess, error) {
-	udpAddr, err := net.ResolveUDPAddr(network, addr)
-	if err != nil {
-		return nil, err
-	}
-	conn, err := net.DialUDP(udpAddr.Network(), nil, udpAddr)
-	if err != nil {
-		return nil, err
-	}
-	s := NewSess(conn, udpAddr, nil)
-	s.dialer = true
-	return s, acceptDial(s)
+	return DialWithConfig(network, addr, nil)
 }
 
-func acceptDial(sess *Sess) error {
-	uid := uuid.NewV4().Bytes()
-	sess.setConnectionID(uid)
+// DialWithConfig connects to the xudp server at addr and performs the
+// handshake, using config for the new session.
+func DialWithConfig(network, addr string, config *Config) (*Sess, error) {
+	return DialEarly(network, addr, config, nil)
+}
 
-	init := &InitFrame{
-		StreamID: rand.Uint32(),
-		Version:  1,
-	}
-	packet := NewPacket(uid, sess.NextSequence(), 1, init)
-	if err := sess.send(packet.Bytes()); err != nil {
-		return err
+// DialEarly is like DialWithConfig but also sends early. When
+// config.SessionTicket is set and still valid, early is sent encrypted in
+// the first packet and is received by the server before the handshake
+// completes, saving a round trip. Otherwise it is sent once the handshake
+// completes.
+//
+// Early data sent with a ticket is not protected against replay: an
+// attacker who captured the first packet can have the server process it
+// again, for as long as the ticket is valid. Only use it for idempotent
+// requests.
+func DialEarly(network, addr string, config *Config, early []byte) (*Sess, error) {
+	d := &Dialer{Config: config}
+	return d.dial(context.Background(), network, addr, early)
+}
+
+// acceptDial performs the handshake of a dialed session, resuming it if
+// config has a session ticket.
+func acceptDial(sess *Sess, config *Config, early []byte) error {
+	if ticket := config.SessionTicket; ticket != nil && !ticket.expired() {
+		err := resume(sess, ticket, early)
+		if err != errResumeRejected {
+			return err
+		}
 	}
+	return handshake(sess, config)
+}
 
-	buf, err := sess.read()
+func handshake(sess *Sess, config *Config) error {
+	uid := sess.ConnectionID[:]
+
+	initAck, err := initiate(sess, config)
 	if err != nil {
 		return err
 	}
 
-	header, err := DecodePacketHeader(buf)
+	session := &SessionFrame{
+		StreamID:     rand.Uint32(),
+		Token:        initAck.Token,
+		CipherSuites: config.cipherSuites(),
+		Nonce:        newNonce(),
+		Version:      sess.version,
+		IdleTimeout:  idleTimeoutMillis(sess.idleTimeout),
+		Versions:     config.versions(),
+	}
+	var private *crypto.PrivateKey
+	if config.PSK == nil || config.PSKForwardSecrecy {
+		var public *crypto.PublicKey
+		private, public, err = crypto.GenerateKeys()
+		if err != nil {
+			return err
+		}
+		copy(session.Key[:], public.Bytes())
+	}
+	if config.PSK != nil {
+		if len(config.PSKIdentity) == 0 {
+			return errors.New("xudp: pre-shared key without identity")
+		}
+		if len(config.PSKIdentity) > maxPSKIdentitySize {
+			return fmt.Errorf("xudp: pre-shared key identity longer than %d bytes", maxPSKIdentitySize)
+		}
+		session.PSKIdentity = config.PSKIdentity
+	}
+	err = sess.sendFlight(func() error {
+		packet := NewPacket(uid, sess.NextSequence(), sess.channel, session)
+		return sess.send(packet.Bytes())
+	})
 	if err != nil {
 		return err
 	}
-	frame := DecodeFrame(header.Type, buf[header.Size():])
-	initAck := frame.(*InitAckFrame)
-
-	private, public, err := crypto.GenerateKeys()
+	frame, err := sess.awaitFrame(StageSession, SessAck)
 	if err != nil {
 		return err
 	}
+	sessAck := frame.(*SessAckFrame)
+	if err := verifyServer(config, sess.ConnectionID, session, sessAck); err != nil {
+		return err
+	}
+	sess.negotiateIdleTimeout(sessAck.IdleTimeout)
 
-	session := &SessionFrame{
-		StreamID: rand.Uint32(),
-		Token:    initAck.Token,
+	var secret []byte
+	if private != nil {
+		pk := crypto.GeneratePublicKey(sessAck.Key[:])
+		secret, err = crypto.ComputeSecret(private, pk)
+		if err != nil {
+			return err
+		}
 	}
-	copy(session.Key[:], public.Bytes())
-	packet = NewPacket(uid, sess.NextSequence(), 1, session)
-	if err := sess.send(packet.Bytes()); err != nil {
-		return err
+	transcript := handshakeTranscript(sess.ConnectionID, session, sessAck)
+	if config.PSK != nil {
+		if sessAck.Flags&flagPSK == 0 {
+			return fmt.Errorf("%w: pre-shared key was not accepted", ErrServerAuthentication)
+		}
+		secret = pskSecret(config.PSK, secret, session.Nonce, sessAck.Nonce)
+		binder := pskBinder(secret, transcript)
+		if !hmac.Equal(binder[:], sessAck.Binder[:]) {
+			return fmt.Errorf("%w: invalid pre-shared key binder", ErrServerAuthentication)
+		}
+		sess.pskIdentity = config.PSKIdentity
+	}
+	if !offered(session.CipherSuites, sessAck.CipherSuite) {
+		return fmt.Errorf("xudp: server selected cipher suite %v which was not offered", sessAck.CipherSuite)
+	}
+	sess.setSecret(secret, sessAck.CipherSuite)
+	if sessAck.Flags&flagCertificate != 0 {
+		certs, err := receiveCertificate(sess, config, transcript)
+		if err != nil {
+			return err
+		}
+		sess.peerCertificates = certs
+	} else if config.RootCAs != nil || config.ServerName != "" {
+		return fmt.Errorf("%w: server sent no certificate", ErrServerAuthentication)
+	}
+	if sessAck.Flags&flagClientAuth != 0 {
+		if err := authenticate(sess, config, transcript); err != nil {
+			return err
+		}
 	}
+	if sessAck.Flags&flagTicket != 0 {
+		return receiveTicket(sess)
+	}
+	return nil
+}
 
-	buf, err = sess.read()
-	if err != nil {
-		return err
+// receiveCertificate reads the Certificate frames following SessAck and
+// verifies the certificate chain they carry.
+func receiveCertificate(sess *Sess, config *Config, transcript []byte) ([]*x509.Certificate, error) {
+	assembler := &certificateAssembler{}
+	for {
+		frame, err := sess.awaitFrame(StageCertificate, Certificate)
+		if err != nil {
+			return nil, err
+		}
+		certificate := frame.(*CertificateFrame)
+		done, err := assembler.add(certificate)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return verifyCertificate(config, assembler.buf.Bytes(), transcript)
+		}
 	}
+}
 
-	header, err = DecodePacketHeader(buf)
+// authenticate answers the client authentication request of the server and
+// waits for its decision.
+func authenticate(sess *Sess, config *Config, transcript []byte) error {
+	auth := newAuthFrame(config, transcript)
+	err := sess.sendFlight(func() error {
+		return sess.writeFrame(auth)
+	})
 	if err != nil {
 		return err
 	}
-	frame = DecodeFrame(header.Type, buf[header.Size():])
-	sessAck := frame.(*SessAckFrame)
-
-	pk := crypto.GeneratePublicKey(sessAck.Key[:])
-	secret, err := crypto.ComputeSecret(private, pk)
+	frame, err := sess.awaitFrame(StageAuth, AuthAck)
 	if err != nil {
 		return err
 	}
-	sess.secretKey = secret
+	ack := frame.(*AuthAckFrame)
+	if ack.Status != AuthAccepted {
+		return ErrClientRejected
+	}
 	return nil
 }
+
+func offered(suites []crypto.CipherSuite, suite crypto.CipherSuite) bool {
+	for _, s := range suites {
+		if s == suite {
+			return suite.Supported()
+		}
+	}
+	return false
+}